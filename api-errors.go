@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HTTP 接口错误码，客户端可据此分支处理，message 仅供展示
+const (
+	ErrCodeInvalidRequest   = "INVALID_REQUEST"   // 请求格式不符合接口定义
+	ErrCodeInvalidOrder     = "INVALID_ORDER"     // 订单字段不满足下单规则
+	ErrCodeUnsupportedPair  = "UNSUPPORTED_PAIR"  // 交易对不存在
+	ErrCodeMissingAuth      = "MISSING_AUTH"      // 缺少认证请求头
+	ErrCodeInvalidTimestamp = "INVALID_TIMESTAMP" // 时间戳或 recvWindow 格式错误
+	ErrCodeRequestExpired   = "REQUEST_EXPIRED"   // 时间戳超出 recvWindow，检查本地时钟
+	ErrCodeInvalidAPIKey    = "INVALID_API_KEY"   // API Key 不存在或已禁用
+	ErrCodeInvalidSignature = "INVALID_SIGNATURE"
+	ErrCodePermissionDenied = "PERMISSION_DENIED" // API Key 缺少所需权限
+	ErrCodeRateLimited      = "RATE_LIMITED"      // 按 Retry-After 等待后重试
+	ErrCodeOrderNotFound    = "ORDER_NOT_FOUND"
+	ErrCodeDuplicateOrderID = "DUPLICATE_ORDER_ID"
+	ErrCodeOrderClosed      = "ORDER_CLOSED"       // 订单已成交、撤销或拒绝
+	ErrCodeRiskRejected     = "RISK_REJECTED"      // 订单未通过下单前风控
+	ErrCodeMarketNotTrading = "MARKET_NOT_TRADING" // 交易对当前状态不接受该操作，如熔断暂停
+	ErrCodeTimeout          = "TIMEOUT"            // 等待撮合引擎结果超时，结果未知
+	ErrCodeInternal         = "INTERNAL_ERROR"
+)
+
+// APIError 错误响应体中的错误
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse 统一的错误响应：{"error": {"code": "...", "message": "..."}}
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// apiError 带 HTTP 状态码和错误码的错误，可在各层之间传递后由 writeAPIError 写入响应
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// invalidOrder 订单校验失败
+func invalidOrder(message string) *apiError {
+	return newAPIError(http.StatusBadRequest, ErrCodeInvalidOrder, message)
+}
+
+// errorCode 返回错误对应的错误码，非 apiError 视为内部错误
+func errorCode(err error) string {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return ErrCodeInternal
+}
+
+// writeError 以统一格式写入错误响应
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// writeAPIError 写入 apiError，其它错误按内部错误处理，不暴露细节
+func writeAPIError(w http.ResponseWriter, err error) {
+	var e *apiError
+	if errors.As(err, &e) {
+		writeError(w, e.status, e.code, e.message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, ErrCodeInternal, "服务器内部错误")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// LiquidityAuction 集合竞价成交没有主动方，买卖双方的成交明细都使用该值
+const LiquidityAuction = "AUCTION"
+
+// AuctionIndicative 集合竞价的参考成交价和成交量，竞价期间每次订单簿变化后推送
+type AuctionIndicative struct {
+	Pair      string          `json:"pair"`
+	Price     decimal.Decimal `json:"price"`              // 参考成交价，没有可成交的订单时为 0
+	Volume    decimal.Decimal `json:"volume"`             // 按参考价可成交的数量
+	Surplus   decimal.Decimal `json:"surplus"`            // 按参考价未能成交的数量，正数为买方剩余，负数为卖方剩余
+	EndTime   int64           `json:"end_time,omitempty"` // 计划撮合时间（毫秒），0 表示由管理员结束
+	Timestamp int64           `json:"timestamp"`          // 毫秒
+}
+
+// auctionPrice 计算集合竞价成交价，bids 和 asks 为订单簿的聚合价位，顺序不限。规则依次为：
+// 可成交量最大；未成交量的绝对值最小；买方都有剩余时取最高价，卖方都有剩余时取最低价；
+// 最接近参考价 ref（最近成交价），没有参考价时取剩余候选价格的中间价，按交易对的 tick size 取整，
+// 取整后仍限制在候选价格范围内
+func auctionPrice(bids, asks []OrderBookLevel, ref decimal.Decimal, market MarketConfig) (price, volume, surplus decimal.Decimal) {
+	seen := make(map[string]bool)
+	var candidates []decimal.Decimal
+	for _, level := range append(append([]OrderBookLevel{}, bids...), asks...) {
+		if key := level.Price.String(); !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, level.Price)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].LessThan(candidates[j]) })
+
+	// 按候选价格累计可成交的买量（价格不低于候选价）和卖量（价格不高于候选价）
+	bids = sortedByPrice(bids, true)
+	asks = sortedByPrice(asks, false)
+	buys := make([]decimal.Decimal, len(candidates))
+	sells := make([]decimal.Decimal, len(candidates))
+	total, k := decimal.Zero, 0
+	for i := len(candidates) - 1; i >= 0; i-- {
+		for ; k < len(bids) && bids[k].Price.GreaterThanOrEqual(candidates[i]); k++ {
+			total = total.Add(bids[k].Amount)
+		}
+		buys[i] = total
+	}
+	total, k = decimal.Zero, 0
+	for i := range candidates {
+		for ; k < len(asks) && asks[k].Price.LessThanOrEqual(candidates[i]); k++ {
+			total = total.Add(asks[k].Amount)
+		}
+		sells[i] = total
+	}
+
+	type candidate struct {
+		price, volume, surplus decimal.Decimal
+	}
+	var best []candidate
+	for i, p := range candidates {
+		c := candidate{price: p, volume: min(buys[i], sells[i]), surplus: buys[i].Sub(sells[i])}
+		if !c.volume.IsPositive() {
+			continue
+		}
+		switch {
+		case len(best) == 0 || c.volume.GreaterThan(best[0].volume):
+			best = []candidate{c}
+		case c.volume.Equal(best[0].volume):
+			if c.surplus.Abs().LessThan(best[0].surplus.Abs()) {
+				best = []candidate{c}
+			} else if c.surplus.Abs().Equal(best[0].surplus.Abs()) {
+				best = append(best, c)
+			}
+		}
+	}
+	if len(best) == 0 {
+		return decimal.Zero, decimal.Zero, decimal.Zero
+	}
+
+	// 候选价格按升序排列
+	chosen := best[0]
+	allBuy, allSell := true, true
+	for _, c := range best {
+		allBuy = allBuy && c.surplus.IsPositive()
+		allSell = allSell && c.surplus.IsNegative()
+	}
+	switch {
+	case len(best) == 1 || allSell:
+		chosen = best[0]
+	case allBuy:
+		chosen = best[len(best)-1]
+	case ref.IsPositive():
+		for _, c := range best[1:] {
+			if c.price.Sub(ref).Abs().LessThan(chosen.price.Sub(ref).Abs()) {
+				chosen = c
+			}
+		}
+	default:
+		low, high := best[0].price, best[len(best)-1].price
+		chosen.price = decimal.Min(decimal.Max(market.roundToTick(low.Add(high).Div(decimal.NewFromInt(2))), low), high)
+	}
+	return chosen.price, chosen.volume, chosen.surplus
+}
+
+// sortedByPrice 复制并按价格排序，desc 为 true 时降序
+func sortedByPrice(levels []OrderBookLevel, desc bool) []OrderBookLevel {
+	sorted := append([]OrderBookLevel{}, levels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if desc {
+			return sorted[i].Price.GreaterThan(sorted[j].Price)
+		}
+		return sorted[i].Price.LessThan(sorted[j].Price)
+	})
+	return sorted
+}
+
+// orderLevels 把订单按价格聚合为价位
+func orderLevels(orders []Order) []OrderBookLevel {
+	amounts := make(map[string]decimal.Decimal)
+	for _, order := range orders {
+		key := order.Price.String()
+		amounts[key] = amounts[key].Add(order.Amount)
+	}
+	return sortedLevels(amounts, false)
+}
+
+// auctionReference 集合竞价的参考价：最近成交价
+func auctionReference(pair string) decimal.Decimal {
+	return defaultMarketData.latestTicker(pair).LastPrice
+}
+
+// auctionIndicativeInterval 集合竞价参考价的推送间隔，间隔内的多次变化合并为一次计算
+const auctionIndicativeInterval = 500 * time.Millisecond
+
+var (
+	auctionDirty   = make(map[string]bool) // 上次推送后订单簿有变化的交易对
+	auctionDirtyMu sync.Mutex
+)
+
+// markAuctionDirty 竞价期间每条指令处理后调用，参考价由 runAuctionIndicativePublisher 按固定频率推送
+func markAuctionDirty(pair string) {
+	auctionDirtyMu.Lock()
+	auctionDirty[pair] = true
+	auctionDirtyMu.Unlock()
+}
+
+// runAuctionIndicativePublisher 按 auctionIndicativeInterval 推送有变化且仍在竞价中的交易对的参考价
+func runAuctionIndicativePublisher(rc *RedisClient) {
+	ticker := time.NewTicker(auctionIndicativeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		auctionDirtyMu.Lock()
+		pairs := auctionDirty
+		auctionDirty = make(map[string]bool)
+		auctionDirtyMu.Unlock()
+		for pair := range pairs {
+			if currentMarketState(pair).State == MarketAuction {
+				publishAuctionIndicative(rc, pair)
+			}
+		}
+	}
+}
+
+// publishAuctionIndicative 按撮合引擎维护的聚合盘口计算并推送参考成交价
+func publishAuctionIndicative(rc *RedisClient, pair string) {
+	market, _ := getMarket(pair)
+	state := getDepthState(pair)
+	state.mu.Lock()
+	snapshot := state.snapshot(pair)
+	state.mu.Unlock()
+	price, volume, surplus := auctionPrice(snapshot.Bids, snapshot.Asks, auctionReference(pair), market)
+	indicative := AuctionIndicative{
+		Pair:      pair,
+		Price:     price,
+		Volume:    volume,
+		Surplus:   surplus,
+		EndTime:   currentMarketState(pair).Until,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	if err := rc.PublishAuctionIndicative(indicative); err != nil {
+		log.Printf("发布集合竞价参考价失败: %v", err)
+	}
+}
+
+// uncrossAuction 集合竞价撮合：按 auctionPrice 算出的统一价格成交全部可成交数量，
+// 价格优先、时间优先分配。未成交的订单留在订单簿中，之后由 matchOrdersPriceLimit 连续撮合
+func uncrossAuction(rc *RedisClient, pair string) error {
+	bids, err := rc.GetAllOrders("bids:" + pair)
+	if err != nil {
+		return err
+	}
+	asks, err := rc.GetAllOrders("asks:" + pair)
+	if err != nil {
+		return err
+	}
+	market, _ := getMarket(pair)
+	price, volume, _ := auctionPrice(orderLevels(bids), orderLevels(asks), auctionReference(pair), market)
+	log.Printf("集合竞价撮合 %s: 价格 %s, 数量 %s", pair, price, volume)
+	if !volume.IsPositive() {
+		return nil
+	}
+
+	// 价格优先、时间优先，只保留按成交价可以成交的订单
+	sort.Slice(bids, func(i, j int) bool {
+		if !bids[i].Price.Equal(bids[j].Price) {
+			return bids[i].Price.GreaterThan(bids[j].Price)
+		}
+		return timePriority(bids[i], bids[j])
+	})
+	sort.Slice(asks, func(i, j int) bool {
+		if !asks[i].Price.Equal(asks[j].Price) {
+			return asks[i].Price.LessThan(asks[j].Price)
+		}
+		return timePriority(asks[i], asks[j])
+	})
+
+	events := &orderEventRecorder{pair: pair}
+	before := append(append([]Order{}, bids...), asks...)
+	return events.run(rc, func() error {
+		remaining := volume
+		i, j := 0, 0
+		for remaining.IsPositive() && i < len(bids) && j < len(asks) {
+			bid, ask := &bids[i], &asks[j]
+			amount := min(remaining, min(bid.Amount, ask.Amount))
+			trade := Trade{
+				TradeID:    uuid.New().String(),
+				Pair:       pair,
+				BidOrderID: bid.OrderID,
+				AskOrderID: ask.OrderID,
+				Price:      price,
+				Amount:     amount,
+				Timestamp:  time.Now().Unix(),
+			}
+			events.addTrade(trade)
+
+			remaining = remaining.Sub(amount)
+			fillAuctionOrder(events, bid, amount, trade)
+			fillAuctionOrder(events, ask, amount, trade)
+			if !bid.Amount.IsPositive() {
+				i++
+			}
+			if !ask.Amount.IsPositive() {
+				j++
+			}
+		}
+
+		// 一笔订单可能与多笔对手订单成交，按成交前后的数量合并后在一个脚本中写入订单簿
+		var fills []BookFill
+		for k, order := range append(append([]Order{}, bids...), asks...) {
+			if filled := before[k].Amount.Sub(order.Amount); filled.IsPositive() {
+				fills = append(fills, BookFill{Order: before[k], Amount: filled})
+			}
+		}
+		return events.applyFills(rc, fills, nil)
+	})
+}
+
+// fillAuctionOrder 记录订单状态变化，order.Amount 扣减为剩余数量
+func fillAuctionOrder(events *orderEventRecorder, order *Order, amount decimal.Decimal, trade Trade) {
+	order.Amount = order.Amount.Sub(amount)
+	fill := newFill(trade, LiquidityAuction)
+	if order.Amount.IsPositive() {
+		events.setOrderStatus(*order, "PARTIALLY_FILLED", order.Amount, fill)
+		return
+	}
+	events.setOrderStatus(*order, "FILLED", order.Amount, fill)
+}
+
+// broadcastAuctionIndicative 推送参考成交价到 auction:<pair> 频道
+func broadcastAuctionIndicative(indicative AuctionIndicative) {
+	publish("auction:"+indicative.Pair, indicative)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func testLevels(pairs ...string) []OrderBookLevel {
+	var out []OrderBookLevel
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, OrderBookLevel{Price: decimal.RequireFromString(pairs[i]), Amount: decimal.RequireFromString(pairs[i+1])})
+	}
+	return out
+}
+
+func TestAuctionPrice(t *testing.T) {
+	tests := []struct {
+		name                   string
+		bids, asks             []OrderBookLevel
+		ref, tick              string
+		price, volume, surplus string
+	}{
+		{
+			name: "最大成交量",
+			bids: testLevels("101", "2", "100", "3"),
+			asks: testLevels("99", "1", "100", "2", "102", "5"),
+			ref:  "0", tick: "0.01",
+			price: "100", volume: "3", surplus: "2",
+		},
+		{
+			name: "买方都有剩余取最高价",
+			bids: testLevels("101", "5"),
+			asks: testLevels("99", "1", "100", "1"),
+			ref:  "0", tick: "0.01",
+			price: "101", volume: "2", surplus: "3",
+		},
+		{
+			name: "卖方都有剩余取最低价",
+			bids: testLevels("99", "1", "101", "1"),
+			asks: testLevels("98", "5"),
+			ref:  "0", tick: "0.01",
+			price: "98", volume: "2", surplus: "-3",
+		},
+		{
+			name: "最接近参考价",
+			bids: testLevels("100.10", "1"),
+			asks: testLevels("100.00", "1"),
+			ref:  "100.08", tick: "0.01",
+			price: "100.1", volume: "1", surplus: "0",
+		},
+		{
+			name: "中间价",
+			bids: testLevels("100.10", "1"),
+			asks: testLevels("100.00", "1"),
+			ref:  "0", tick: "0.01",
+			price: "100.05", volume: "1", surplus: "0",
+		},
+		{
+			name: "中间价按 tick 取整",
+			bids: testLevels("100.10", "1"),
+			asks: testLevels("100.00", "1"),
+			ref:  "0", tick: "0.04",
+			price: "100.04", volume: "1", surplus: "0",
+		},
+		{
+			name: "取整后低于候选价格时取最低价",
+			bids: testLevels("1.03", "1"),
+			asks: testLevels("1.01", "1"),
+			ref:  "0", tick: "0.05",
+			price: "1.01", volume: "1", surplus: "0",
+		},
+		{
+			name: "取整后高于候选价格时取最高价",
+			bids: testLevels("1.09", "1"),
+			asks: testLevels("1.07", "1"),
+			ref:  "0", tick: "0.05",
+			price: "1.09", volume: "1", surplus: "0",
+		},
+		{
+			name: "没有可成交的订单",
+			bids: testLevels("99", "1"),
+			asks: testLevels("100", "1"),
+			ref:  "0", tick: "0.01",
+			price: "0", volume: "0", surplus: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			market := MarketConfig{TickSize: decimal.RequireFromString(tt.tick)}
+			price, volume, surplus := auctionPrice(tt.bids, tt.asks, decimal.RequireFromString(tt.ref), market)
+			if !price.Equal(decimal.RequireFromString(tt.price)) || !volume.Equal(decimal.RequireFromString(tt.volume)) || !surplus.Equal(decimal.RequireFromString(tt.surplus)) {
+				t.Errorf("auctionPrice = (%s, %s, %s), 期望 (%s, %s, %s)", price, volume, surplus, tt.price, tt.volume, tt.surplus)
+			}
+		})
+	}
+}
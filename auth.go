@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// API Key 权限
+const (
+	ScopeRead     = "read"
+	ScopeTrade    = "trade"
+	ScopeAdmin    = "admin"    // 修改交易对状态等运维操作
+	ScopeWithdraw = "withdraw" // API Key 禁止提现，即使配置了也不生效
+)
+
+// 签名请求头
+const (
+	headerAPIKey     = "X-API-KEY"
+	headerTimestamp  = "X-TIMESTAMP"   // 毫秒时间戳
+	headerRecvWindow = "X-RECV-WINDOW" // 请求有效期（毫秒），可选
+	headerSignature  = "X-SIGNATURE"   // hex(HMAC-SHA256(secret, timestamp + method + request_uri + body))
+
+	defaultRecvWindow = 5000  // 默认请求有效期（毫秒）
+	maxRecvWindow     = 60000 // 最大请求有效期（毫秒）
+	maxSignedBodySize = 1 << 20
+)
+
+// Principal 已认证的调用方
+type Principal struct {
+	APIKey string
+	UserID int
+	Scopes map[string]bool
+}
+
+type principalContextKey struct{}
+
+// HasScope 是否拥有指定权限
+func (p *Principal) HasScope(scope string) bool {
+	if scope == ScopeWithdraw {
+		return false
+	}
+	return p.Scopes[scope]
+}
+
+// principalFrom 从请求上下文中取出调用方，未认证时返回 nil
+func principalFrom(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// signRequest 计算请求签名
+func signRequest(secret, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + method + requestURI))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newPrincipal 根据 API Key 记录构造调用方
+func newPrincipal(key *APIKeyModel) *Principal {
+	principal := &Principal{APIKey: key.APIKey, UserID: key.UserID, Scopes: make(map[string]bool)}
+	for _, scope := range strings.Split(key.Scopes, ",") {
+		principal.Scopes[strings.TrimSpace(scope)] = true
+	}
+	return principal
+}
+
+// verifyAPIKey 校验时间窗口、API Key 和签名，sign 根据 secret 计算期望的签名。
+// 返回的错误为 *apiError，信息可直接返回给调用方
+func verifyAPIKey(pc *PostgresClient, apiKey, timestamp, recvWindowStr, signature string, sign func(secret string) string) (*Principal, error) {
+	if apiKey == "" || timestamp == "" || signature == "" {
+		return nil, newAPIError(http.StatusUnauthorized, ErrCodeMissingAuth, "缺少认证信息")
+	}
+
+	// 校验时间窗口
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, newAPIError(http.StatusUnauthorized, ErrCodeInvalidTimestamp, "无效的时间戳")
+	}
+	recvWindow := int64(defaultRecvWindow)
+	if recvWindowStr != "" {
+		recvWindow, err = strconv.ParseInt(recvWindowStr, 10, 64)
+		if err != nil || recvWindow <= 0 || recvWindow > maxRecvWindow {
+			return nil, newAPIError(http.StatusUnauthorized, ErrCodeInvalidTimestamp, "无效的 recvWindow")
+		}
+	}
+	now := time.Now().UnixMilli()
+	if ts > now+1000 || now-ts > recvWindow {
+		return nil, newAPIError(http.StatusUnauthorized, ErrCodeRequestExpired, "请求已过期")
+	}
+
+	key, err := pc.GetAPIKey(apiKey)
+	if err != nil || !key.Enabled {
+		log.Printf("API Key 校验失败: %s, %v", apiKey, err)
+		return nil, newAPIError(http.StatusUnauthorized, ErrCodeInvalidAPIKey, "API Key 无效")
+	}
+	if !hmac.Equal([]byte(sign(key.Secret)), []byte(strings.ToLower(signature))) {
+		return nil, newAPIError(http.StatusUnauthorized, ErrCodeInvalidSignature, "签名错误")
+	}
+	return newPrincipal(key), nil
+}
+
+// authenticate 校验 API Key 和 HMAC 签名，通过后把调用方写入请求上下文
+func authenticate(pc *PostgresClient) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 读取请求体参与签名，之后还原供处理函数使用
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "读取请求失败")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			timestamp := r.Header.Get(headerTimestamp)
+			principal, err := verifyAPIKey(pc, r.Header.Get(headerAPIKey), timestamp, r.Header.Get(headerRecvWindow), r.Header.Get(headerSignature),
+				func(secret string) string {
+					return signRequest(secret, timestamp, r.Method, r.URL.RequestURI(), body)
+				})
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+		})
+	}
+}
+
+// requireScope 要求调用方拥有指定权限
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFrom(r)
+		if principal == nil {
+			writeError(w, http.StatusUnauthorized, ErrCodeMissingAuth, "未认证")
+			return
+		}
+		if !principal.HasScope(scope) {
+			writeError(w, http.StatusForbidden, ErrCodePermissionDenied, "API Key 没有 "+scope+" 权限")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxBatchSize = 20 // 单次批量下单或撤单的最大数量
+
+// 批量操作中单项的结果
+const (
+	BatchAccepted  = "ACCEPTED"
+	BatchDuplicate = "DUPLICATE" // client_order_id 重复，返回原订单
+	BatchRejected  = "REJECTED"
+)
+
+// BatchOrderRequest 批量下单请求
+type BatchOrderRequest struct {
+	Orders []Order `json:"orders"`
+}
+
+// BatchCancelRequest 批量撤单请求，order_ids 和 client_order_ids 可混用
+type BatchCancelRequest struct {
+	OrderIDs       []string `json:"order_ids"`
+	ClientOrderIDs []string `json:"client_order_ids"`
+}
+
+// BatchResult 批量操作中单项的结果，Index 为请求中的位置
+type BatchResult struct {
+	Index         int    `json:"index"`
+	OrderID       string `json:"order_id,omitempty"`
+	ClientOrderID string `json:"client_order_id,omitempty"`
+	Result        string `json:"result"`           // ACCEPTED、DUPLICATE 或 REJECTED
+	Status        string `json:"status,omitempty"` // DUPLICATE 时原订单的状态
+	Code          string `json:"code,omitempty"`   // REJECTED 时的错误码
+	Error         string `json:"error,omitempty"`
+}
+
+// reject 标记为 REJECTED 并记录错误码和原因
+func (r *BatchResult) reject(err error) {
+	r.Result, r.Code, r.Error = BatchRejected, errorCode(err), err.Error()
+}
+
+// handleBatchOrder 处理 POST /orders/batch 请求。
+// 校验通过的订单作为一条指令提交，撮合引擎连续处理，中间不会插入其他订单
+func handleBatchOrder(pc *PostgresClient, rc *RedisClient, limiter *rateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchOrderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "无效的订单格式")
+			log.Printf("解析批量订单失败: %v", err)
+			return
+		}
+		if len(req.Orders) == 0 || len(req.Orders) > maxBatchSize {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("订单数量必须在 1 到 %d 之间", maxBatchSize))
+			return
+		}
+		if !limiter.allowRequest(w, r, LimitOrders, len(req.Orders)) {
+			return
+		}
+
+		principal := principalFrom(r)
+		results := make([]BatchResult, len(req.Orders))
+		var accepted []Order
+		var acceptedIdx []int
+		for i, order := range req.Orders {
+			order.UserID = principal.UserID
+			order.APIKey = principal.APIKey
+			results[i] = BatchResult{Index: i, OrderID: order.OrderID, ClientOrderID: order.ClientOrderID}
+			if err := validateOrder(defaultPair, &order); err != nil {
+				results[i].reject(err)
+				continue
+			}
+			results[i].OrderID = order.OrderID
+
+			existing, err := saveNewOrder(pc, order)
+			if existing != nil {
+				results[i].OrderID = existing.OrderID
+				results[i].Result, results[i].Status = BatchDuplicate, existing.Status
+				continue
+			}
+			if err != nil {
+				if !errors.Is(err, errDuplicateOrderID) {
+					log.Printf("保存订单到数据库失败: %v", err)
+					err = errSaveOrder
+				}
+				results[i].reject(err)
+				continue
+			}
+			// 已接受的订单已落库，后续订单的风控检查会计入它们
+			if err := checkOrderEntry(pc, rc, defaultPair, order); err != nil {
+				rejectOrders(pc, order)
+				results[i].reject(err)
+				continue
+			}
+			results[i].Result = BatchAccepted
+			accepted = append(accepted, order)
+			acceptedIdx = append(acceptedIdx, i)
+		}
+
+		if len(accepted) > 0 {
+			if err := rc.SubmitCommand(EngineCommand{Type: CommandNewBatch, Orders: accepted}); err != nil {
+				log.Printf("提交批量订单到 Redis 失败: %v", err)
+				rejectOrders(pc, accepted...)
+				for _, i := range acceptedIdx {
+					results[i].reject(errSubmitOrder)
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}
+}
+
+// handleBatchCancel 处理 DELETE /orders/batch 请求，可撤销的订单作为一条指令提交
+func handleBatchCancel(pc *PostgresClient, rc *RedisClient, limiter *rateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchCancelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "无效的撤单格式")
+			log.Printf("解析批量撤单失败: %v", err)
+			return
+		}
+		total := len(req.OrderIDs) + len(req.ClientOrderIDs)
+		if total == 0 || total > maxBatchSize {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("撤单数量必须在 1 到 %d 之间", maxBatchSize))
+			return
+		}
+		if !limiter.allowRequest(w, r, LimitCancels, total) {
+			return
+		}
+
+		userID := principalFrom(r).UserID
+		results := make([]BatchResult, 0, total)
+		var orderIDs []string
+		var acceptedIdx []int
+		// 同一订单可能以 order_id 和 client_order_id 重复出现，只有第一次撤销有效
+		seen := make(map[string]bool)
+		check := func(result BatchResult, order *OrderModel, err error) {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && order.UserID != userID):
+				result.reject(errOrderNotFound)
+			case err != nil:
+				log.Printf("查询订单失败: %v", err)
+				result.reject(errQueryOrder)
+			case !isOpenStatus(order.Status):
+				result.OrderID = order.OrderID
+				result.Status = order.Status
+				result.reject(errOrderClosed)
+			case seen[order.OrderID]:
+				result.OrderID = order.OrderID
+				result.reject(errDuplicateCancel)
+			default:
+				seen[order.OrderID] = true
+				result.OrderID = order.OrderID
+				if err := checkCancelState(order.Pair); err != nil {
+					result.reject(err)
+					break
+				}
+				result.Result = BatchAccepted
+				orderIDs = append(orderIDs, order.OrderID)
+				acceptedIdx = append(acceptedIdx, len(results))
+			}
+			results = append(results, result)
+		}
+		for _, orderID := range req.OrderIDs {
+			result := BatchResult{Index: len(results), OrderID: orderID}
+			if _, err := uuid.Parse(orderID); err != nil {
+				result.reject(newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "订单 ID 必须是 UUID"))
+				results = append(results, result)
+				continue
+			}
+			order, err := pc.GetOrder(orderID)
+			check(result, order, err)
+		}
+		for _, clientOrderID := range req.ClientOrderIDs {
+			result := BatchResult{Index: len(results), ClientOrderID: clientOrderID}
+			order, err := pc.GetOrderByClientOrderID(userID, clientOrderID)
+			check(result, order, err)
+		}
+
+		if len(orderIDs) > 0 {
+			if err := rc.SubmitCommand(EngineCommand{Type: CommandCancelBatch, OrderIDs: orderIDs, UserID: userID}); err != nil {
+				log.Printf("提交批量撤单到 Redis 失败: %v", err)
+				for _, i := range acceptedIdx {
+					results[i].reject(errSubmitCancel)
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}
+}
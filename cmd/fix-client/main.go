@@ -0,0 +1,227 @@
+// fix-client 脚本化的 FIX 4.4 客户端，用于本地验证 FIX 网关：
+// 登录后依次下限价单、改单、撤单，再下一笔市价单，最后登出。收发的消息以 | 分隔打印
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	soh        = '\x01'
+	timeLayout = "20060102-15:04:05.000"
+)
+
+type field struct {
+	tag   int
+	value string
+}
+
+type message []field
+
+func (m message) get(tag int) string {
+	for _, f := range m {
+		if f.tag == tag {
+			return f.value
+		}
+	}
+	return ""
+}
+
+func (m message) String() string {
+	var parts []string
+	for _, f := range m {
+		parts = append(parts, fmt.Sprintf("%d=%s", f.tag, f.value))
+	}
+	return strings.Join(parts, "|")
+}
+
+// client FIX 会话的客户端一侧
+type client struct {
+	conn         net.Conn
+	senderCompID string
+	targetCompID string
+	mu           sync.Mutex // 读循环自动应答 TestRequest，与脚本并发发送
+	seq          int
+	incoming     chan message
+}
+
+// send 填充标准头和校验和后发送，fields 不含 MsgType
+func (c *client) send(msgType string, fields ...field) {
+	c.sendAt(time.Now().UTC().Format(timeLayout), msgType, fields...)
+}
+
+// sendAt 以指定的 SendingTime 发送，Logon 签名需要使用同一个时间
+func (c *client) sendAt(sendingTime, msgType string, fields ...field) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	var body bytes.Buffer
+	header := []field{{35, msgType}, {49, c.senderCompID}, {56, c.targetCompID}, {34, strconv.Itoa(c.seq)}, {52, sendingTime}}
+	for _, f := range append(header, fields...) {
+		fmt.Fprintf(&body, "%d=%s%c", f.tag, f.value, soh)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "8=FIX.4.4%c9=%d%c", soh, body.Len(), soh)
+	buf.Write(body.Bytes())
+	sum := 0
+	for _, b := range buf.Bytes() {
+		sum += int(b)
+	}
+	fmt.Fprintf(&buf, "10=%03d%c", sum%256, soh)
+
+	log.Printf(">> %s", strings.ReplaceAll(buf.String(), string(soh), "|"))
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		log.Fatalf("发送失败: %v", err)
+	}
+}
+
+// readLoop 读取网关消息，自动应答 TestRequest
+func (c *client) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		begin, err := r.ReadString(soh)
+		if err != nil {
+			close(c.incoming)
+			return
+		}
+		lengthField, err := r.ReadString(soh)
+		if err != nil {
+			close(c.incoming)
+			return
+		}
+		length, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(lengthField, "9="), string(soh)))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			close(c.incoming)
+			return
+		}
+		if _, err := r.ReadString(soh); err != nil {
+			close(c.incoming)
+			return
+		}
+
+		var msg message
+		for _, kv := range strings.Split(strings.TrimSuffix(string(body), string(soh)), string(soh)) {
+			tag, value, _ := strings.Cut(kv, "=")
+			t, _ := strconv.Atoi(tag)
+			msg = append(msg, field{t, value})
+		}
+		log.Printf("<< %s", strings.TrimSuffix(begin, string(soh))+"|"+msg.String())
+		if msg.get(35) == "1" {
+			c.send("0", field{112, msg.get(112)})
+		}
+		c.incoming <- msg
+	}
+}
+
+// expect 等待指定类型的消息，返回第一条匹配的消息
+func (c *client) expect(msgType string, timeout time.Duration) message {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg, ok := <-c.incoming:
+			if !ok {
+				log.Fatal("连接已关闭")
+			}
+			if msg.get(35) == msgType {
+				return msg
+			}
+		case <-deadline:
+			log.Fatalf("等待消息 %s 超时", msgType)
+		}
+	}
+}
+
+// expectExec 等待指定 ExecType 的执行报告
+func (c *client) expectExec(execType string, timeout time.Duration) message {
+	deadline := time.Now().Add(timeout)
+	for {
+		msg := c.expect("8", time.Until(deadline))
+		if msg.get(150) == execType {
+			return msg
+		}
+	}
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:9878", "FIX 网关地址")
+	apiKey := flag.String("key", os.Getenv("FIX_API_KEY"), "API Key")
+	secret := flag.String("secret", os.Getenv("FIX_API_SECRET"), "API Secret")
+	sender := flag.String("sender", "CLIENT1", "SenderCompID")
+	target := flag.String("target", "MATCHENGINE", "TargetCompID")
+	symbol := flag.String("symbol", "BTC_USDT", "交易对")
+	price := flag.String("price", "30000", "限价单价格")
+	flag.Parse()
+	if *apiKey == "" || *secret == "" {
+		log.Fatal("需要 -key 和 -secret，或环境变量 FIX_API_KEY 和 FIX_API_SECRET")
+	}
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		log.Fatalf("连接 FIX 网关失败: %v", err)
+	}
+	defer conn.Close()
+	c := &client{conn: conn, senderCompID: *sender, targetCompID: *target, incoming: make(chan message, 100)}
+	go c.readLoop()
+
+	// Logon，签名为 hex(HMAC-SHA256(secret, SendingTime + MsgSeqNum + SenderCompID + TargetCompID))
+	sendingTime := time.Now().UTC().Format(timeLayout)
+	mac := hmac.New(sha256.New, []byte(*secret))
+	mac.Write([]byte(sendingTime + "1" + *sender + *target))
+	c.sendAt(sendingTime, "A", field{98, "0"}, field{108, "30"}, field{141, "Y"}, field{553, *apiKey}, field{554, hex.EncodeToString(mac.Sum(nil))})
+	c.expect("A", 5*time.Second)
+
+	suffix := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	clOrdID := "fix-" + suffix + "-1"
+	replaceID := "fix-" + suffix + "-2"
+	cancelID := "fix-" + suffix + "-3"
+
+	// 限价买单
+	c.send("D", field{11, clOrdID}, field{55, *symbol}, field{54, "1"}, field{38, "0.01"}, field{40, "2"}, field{44, *price},
+		field{60, time.Now().UTC().Format(timeLayout)})
+	ack := c.expect("8", 5*time.Second)
+	if ack.get(150) == "8" {
+		log.Fatalf("下单被拒绝: %s", ack.get(58))
+	}
+
+	// 改单：降价并修改数量
+	newPrice := *price
+	if p, err := strconv.ParseFloat(*price, 64); err == nil {
+		newPrice = strconv.FormatFloat(p*0.99, 'f', 2, 64)
+	}
+	c.send("G", field{11, replaceID}, field{41, clOrdID}, field{55, *symbol}, field{54, "1"}, field{38, "0.02"}, field{40, "2"},
+		field{44, newPrice}, field{60, time.Now().UTC().Format(timeLayout)})
+	c.expectExec("5", 5*time.Second)
+
+	// 撤单
+	c.send("F", field{11, cancelID}, field{41, replaceID}, field{55, *symbol}, field{54, "1"}, field{38, "0.02"},
+		field{60, time.Now().UTC().Format(timeLayout)})
+	c.expectExec("4", 5*time.Second)
+
+	// 市价卖单，订单簿没有买单时收到 ExecType=C
+	c.send("D", field{11, "fix-" + suffix + "-4"}, field{55, *symbol}, field{54, "2"}, field{38, "0.01"}, field{40, "1"},
+		field{60, time.Now().UTC().Format(timeLayout)})
+	c.expect("8", 5*time.Second)
+
+	// 心跳检查
+	c.send("1", field{112, "ping-" + suffix})
+	c.expect("0", 5*time.Second)
+
+	c.send("5")
+	c.expect("5", 5*time.Second)
+	log.Println("脚本执行完成")
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +30,12 @@ type Order struct {
 	Timestamp int64           `json:"timestamp"`
 }
 
+// APIKey 下单使用的 API Key
+type APIKey struct {
+	APIKey string
+	Secret string
+}
+
 type PostgresClient struct {
 	db *gorm.DB
 }
@@ -48,20 +58,21 @@ func (pc *PostgresClient) Close() {
 	}
 }
 
-// GetValidUserIDs 获取 users 表中的所有 user_id
-func (pc *PostgresClient) GetValidUserIDs() ([]int, error) {
-	var userIDs []int
-	if err := pc.db.Table("users").Select("user_id").Find(&userIDs).Error; err != nil {
-		return nil, fmt.Errorf("查询用户失败: %v", err)
+// GetTradeAPIKeys 获取 api_keys 表中所有拥有 trade 权限的 API Key
+func (pc *PostgresClient) GetTradeAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	if err := pc.db.Table("api_keys").Select("api_key, secret").
+		Where("enabled AND scopes LIKE ?", "%trade%").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("查询 API Key 失败: %v", err)
 	}
-	if len(userIDs) == 0 {
-		return nil, fmt.Errorf("users 表为空")
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("api_keys 表中没有可交易的 API Key")
 	}
-	return userIDs, nil
+	return keys, nil
 }
 
-// generateRandomOrder 生成随机订单，支持限价和市价订单
-func generateRandomOrder(userIDs []int) Order {
+// generateRandomOrder 生成随机订单，支持限价和市价订单，下单用户由 API Key 决定
+func generateRandomOrder() Order {
 	orderTypes := []string{"BID", "ASK"}
 	orderKinds := []string{"LIMIT", "MARKET"}
 
@@ -70,7 +81,6 @@ func generateRandomOrder(userIDs []int) Order {
 	amount := decimal.NewFromFloat(0.01 + rand.Float64()*0.99).Round(8)
 	order := Order{
 		OrderID:   uuid.New().String(),
-		UserID:    userIDs[rand.Intn(len(userIDs))],
 		OrderType: orderTypes[rand.Intn(len(orderTypes))],
 		OrderKind: orderKinds[rand.Intn(len(orderKinds))],
 		Price:     price,
@@ -86,14 +96,32 @@ func generateRandomOrder(userIDs []int) Order {
 	return order
 }
 
-// sendOrder 发送订单到 HTTP 接口
-func sendOrder(order Order) error {
+// sign 计算请求签名，hex(HMAC-SHA256(secret, timestamp + method + request_uri + body))
+func sign(secret, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + method + requestURI))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendOrder 使用 API Key 签名后发送订单到 HTTP 接口
+func sendOrder(key APIKey, order Order) error {
 	orderJSON, err := json.Marshal(order)
 	if err != nil {
 		return fmt.Errorf("序列化订单失败: %v", err)
 	}
 
-	resp, err := http.Post("http://localhost:8080/orders", "application/json", bytes.NewBuffer(orderJSON))
+	req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/orders", bytes.NewBuffer(orderJSON))
+	if err != nil {
+		return fmt.Errorf("创建 HTTP 请求失败: %v", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", key.APIKey)
+	req.Header.Set("X-TIMESTAMP", timestamp)
+	req.Header.Set("X-SIGNATURE", sign(key.Secret, timestamp, req.Method, req.URL.RequestURI(), orderJSON))
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("发送 HTTP 请求失败: %v", err)
 	}
@@ -122,21 +150,21 @@ func main() {
 	}
 	defer pc.Close()
 
-	// 获取有效 user_id
-	userIDs, err := pc.GetValidUserIDs()
+	// 获取可交易的 API Key
+	keys, err := pc.GetTradeAPIKeys()
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("有效用户 ID: %v", userIDs)
+	log.Printf("可用 API Key 数量: %d", len(keys))
 
 	// 每 3 秒发送一个随机订单
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		order := generateRandomOrder(userIDs)
+		order := generateRandomOrder()
 		log.Printf("生成订单: %+v", order)
-		if err := sendOrder(order); err != nil {
+		if err := sendOrder(keys[rand.Intn(len(keys))], order); err != nil {
 			log.Printf("发送订单失败: %v", err)
 			continue
 		}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxCountdownTimeout  = 600 // 倒计时撤单的最大超时（秒）
+	countdownPollPeriod  = 500 * time.Millisecond
+	countdownDeadlineKey = "countdown_cancel" // 有序集合，成员 <user_id>:<pair>，分值为截止时间（毫秒）
+)
+
+// CountdownRequest 倒计时撤单请求
+type CountdownRequest struct {
+	Timeout int    `json:"timeout"` // 秒，0 表示关闭
+	Pair    string `json:"pair"`    // 为空表示全部交易对
+}
+
+// handleCountdownCancel 处理 POST /countdown-cancel 请求。
+// 客户端需在超时前再次调用以刷新截止时间，否则撤销该用户的全部挂单
+func handleCountdownCancel(rc *RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CountdownRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "无效的请求格式")
+			return
+		}
+		if req.Timeout < 0 || req.Timeout > maxCountdownTimeout {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("timeout 必须在 0 到 %d 秒之间", maxCountdownTimeout))
+			return
+		}
+		if _, ok := getMarket(req.Pair); req.Pair != "" && !ok {
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupportedPair, "不支持的交易对")
+			return
+		}
+
+		member := strconv.Itoa(principalFrom(r).UserID) + ":" + req.Pair
+		if req.Timeout == 0 {
+			if err := rc.ClearCountdown(member); err != nil {
+				writeError(w, http.StatusInternalServerError, ErrCodeInternal, "关闭倒计时撤单失败")
+				log.Printf("关闭倒计时撤单失败: %v", err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]interface{}{"timeout": 0})
+			return
+		}
+
+		deadline := time.Now().Add(time.Duration(req.Timeout) * time.Second).UnixMilli()
+		if err := rc.SetCountdown(member, deadline); err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "设置倒计时撤单失败")
+			log.Printf("设置倒计时撤单失败: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"timeout": req.Timeout, "trigger_at": deadline})
+	}
+}
+
+// runCountdownCancel 定期检查到期的倒计时，提交全部撤单指令。
+// 截止时间保存在 Redis 中，多个 HTTP 实例共享，进程重启后仍然有效
+func runCountdownCancel(rc *RedisClient) {
+	ticker := time.NewTicker(countdownPollPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := rc.PopExpiredCountdowns(time.Now().UnixMilli())
+		if err != nil {
+			log.Printf("检查倒计时撤单失败: %v", err)
+			continue
+		}
+		for _, member := range expired {
+			userIDStr, pair, _ := strings.Cut(member, ":")
+			userID, err := strconv.Atoi(userIDStr)
+			if err != nil {
+				log.Printf("无效的倒计时撤单: %s", member)
+				continue
+			}
+			log.Printf("倒计时到期，撤销用户 %d 的挂单，交易对 %q", userID, pair)
+			if err := rc.SubmitCommand(EngineCommand{Type: CommandCancelAll, UserID: userID, Pair: pair}); err != nil {
+				log.Printf("提交倒计时撤单失败: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const defaultDepthPublishInterval = 100 * time.Millisecond
+
+// depthState 单个交易对的聚合盘口，随订单簿变化增量维护。
+// 变化的价位先记为 dirty，由 runDepthPublisher 按固定频率合并推送
+type depthState struct {
+	mu        sync.Mutex
+	updateID  int64                      // 最近一次推送的序号，单调递增
+	bids      map[string]decimal.Decimal // 价格 -> 数量
+	asks      map[string]decimal.Decimal
+	dirtyBids map[string]bool // 上次推送后有变化的价位
+	dirtyAsks map[string]bool
+}
+
+var (
+	depthStates   = make(map[string]*depthState)
+	depthStatesMu sync.Mutex
+)
+
+func getDepthPublishInterval() time.Duration {
+	intervalStr := os.Getenv("DEPTH_PUBLISH_INTERVAL")
+	if intervalStr == "" {
+		return defaultDepthPublishInterval
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval <= 0 {
+		log.Printf("无效的盘口推送间隔 %q，使用默认: %v", intervalStr, defaultDepthPublishInterval)
+		return defaultDepthPublishInterval
+	}
+	return interval
+}
+
+// getDepthState 获取交易对的盘口状态
+func getDepthState(pair string) *depthState {
+	depthStatesMu.Lock()
+	defer depthStatesMu.Unlock()
+	state, ok := depthStates[pair]
+	if !ok {
+		state = &depthState{
+			bids:      make(map[string]decimal.Decimal),
+			asks:      make(map[string]decimal.Decimal),
+			dirtyBids: make(map[string]bool),
+			dirtyAsks: make(map[string]bool),
+		}
+		depthStates[pair] = state
+	}
+	return state
+}
+
+// seedDepth 用完整快照初始化盘口，只在启动时、撮合开始前调用一次
+func seedDepth(snapshot OrderBookSnapshot) {
+	state := getDepthState(snapshot.Pair)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.bids = levelMap(snapshot.Bids)
+	state.asks = levelMap(snapshot.Asks)
+}
+
+// applyBookChange 订单簿变化回调，side 为 bids 或 asks，delta 为该价位数量的变化量
+func applyBookChange(pair, side string, price, delta decimal.Decimal) {
+	state := getDepthState(pair)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	levels, dirty := state.bids, state.dirtyBids
+	if side == "asks" {
+		levels, dirty = state.asks, state.dirtyAsks
+	}
+	priceStr := price.String()
+	amount := levels[priceStr].Add(delta)
+	if amount.GreaterThan(decimal.Zero) {
+		levels[priceStr] = amount
+	} else {
+		delete(levels, priceStr)
+	}
+	dirty[priceStr] = true
+}
+
+// snapshot 返回当前状态的完整快照，调用方需持有 mu。
+// 快照可能已包含尚未推送的变化，后续增量携带的是价位的最新数量，重复应用不影响结果
+func (s *depthState) snapshot(pair string) OrderBookSnapshot {
+	return OrderBookSnapshot{
+		Pair:     pair,
+		UpdateID: s.updateID,
+		Bids:     sortedLevels(s.bids, true),
+		Asks:     sortedLevels(s.asks, false),
+	}
+}
+
+// midPrice 最优买卖价的中间价，任一侧为空时返回 false
+func (s *depthState) midPrice() (decimal.Decimal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bestBid, bestAsk decimal.Decimal
+	for priceStr := range s.bids {
+		if price, _ := decimal.NewFromString(priceStr); price.GreaterThan(bestBid) {
+			bestBid = price
+		}
+	}
+	for priceStr := range s.asks {
+		if price, _ := decimal.NewFromString(priceStr); bestAsk.IsZero() || price.LessThan(bestAsk) {
+			bestAsk = price
+		}
+	}
+	if bestBid.IsZero() || bestAsk.IsZero() {
+		return decimal.Zero, false
+	}
+	return bestBid.Add(bestAsk).Div(decimal.NewFromInt(2)), true
+}
+
+// flush 取出上次推送后有变化的价位，无变化时返回 false。调用方需持有 mu
+func (s *depthState) flush(pair string) (OrderBookUpdate, bool) {
+	if len(s.dirtyBids) == 0 && len(s.dirtyAsks) == 0 {
+		return OrderBookUpdate{}, false
+	}
+	s.updateID++
+	update := OrderBookUpdate{
+		Pair:     pair,
+		UpdateID: s.updateID,
+		Bids:     dirtyLevels(s.bids, s.dirtyBids, true),
+		Asks:     dirtyLevels(s.asks, s.dirtyAsks, false),
+	}
+	s.dirtyBids = make(map[string]bool)
+	s.dirtyAsks = make(map[string]bool)
+	return update, true
+}
+
+// runDepthPublisher 按固定间隔推送各交易对累积的盘口增量，间隔内的多次变化合并为一条
+func runDepthPublisher(interval time.Duration) {
+	log.Printf("盘口推送间隔: %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		depthStatesMu.Lock()
+		states := make(map[string]*depthState, len(depthStates))
+		for pair, state := range depthStates {
+			states[pair] = state
+		}
+		depthStatesMu.Unlock()
+
+		for pair, state := range states {
+			state.mu.Lock()
+			if update, changed := state.flush(pair); changed {
+				broadcastOrderBook(update)
+				publishGRPCDepth(update)
+			}
+			state.mu.Unlock()
+		}
+	}
+}
+
+func levelMap(levels []OrderBookLevel) map[string]decimal.Decimal {
+	m := make(map[string]decimal.Decimal, len(levels))
+	for _, level := range levels {
+		m[level.Price.String()] = level.Amount
+	}
+	return m
+}
+
+// dirtyLevels 返回有变化价位的最新数量，已移除的价位数量为 0
+func dirtyLevels(levels map[string]decimal.Decimal, dirty map[string]bool, desc bool) []OrderBookLevel {
+	changed := make(map[string]decimal.Decimal, len(dirty))
+	for price := range dirty {
+		changed[price] = levels[price]
+	}
+	return sortedLevels(changed, desc)
+}
+
+// sortedLevels 转为按价格排序的切片，买盘降序、卖盘升序
+func sortedLevels(m map[string]decimal.Decimal, desc bool) []OrderBookLevel {
+	levels := make([]OrderBookLevel, 0, len(m))
+	for priceStr, amount := range m {
+		price, _ := decimal.NewFromString(priceStr)
+		levels = append(levels, OrderBookLevel{Price: price, Amount: amount})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if desc {
+			return levels[i].Price.GreaterThan(levels[j].Price)
+		}
+		return levels[i].Price.LessThan(levels[j].Price)
+	})
+	return levels
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 撮合引擎指令类型
+const (
+	CommandNew         = "NEW"
+	CommandCancel      = "CANCEL"
+	CommandNewBatch    = "NEW_BATCH"    // 批量下单，连续处理
+	CommandCancelBatch = "CANCEL_BATCH" // 批量撤单，连续处理
+	CommandCancelAll   = "CANCEL_ALL"   // 撤销用户的全部挂单，可按交易对和方向过滤
+	CommandReplace     = "REPLACE"      // 改单：撤销 OrderID 后提交 Order，原订单无法撤销时拒绝新订单
+
+	CommandSetMarketState = "SET_MARKET_STATE" // 修改交易对状态
+	CommandResumeMarket   = "RESUME_MARKET"    // 状态到期后恢复交易，状态已被修改时忽略
+)
+
+// EngineCommand 撮合引擎指令，经 incoming_orders 通道由单个订阅者按顺序处理
+type EngineCommand struct {
+	Type     string   `json:"type"`
+	Order    *Order   `json:"order,omitempty"`     // NEW、REPLACE
+	Orders   []Order  `json:"orders,omitempty"`    // NEW_BATCH
+	OrderID  string   `json:"order_id,omitempty"`  // CANCEL、REPLACE
+	OrderIDs []string `json:"order_ids,omitempty"` // CANCEL_BATCH
+	UserID   int      `json:"user_id,omitempty"`   // 撤单时用于校验订单归属
+	Pair     string   `json:"pair,omitempty"`      // CANCEL_ALL 过滤条件，为空表示全部交易对；SET_MARKET_STATE、RESUME_MARKET 的交易对
+	Side     string   `json:"side,omitempty"`      // CANCEL_ALL 过滤条件，BID、ASK 或为空
+	APIKey   string   `json:"api_key,omitempty"`   // CANCEL_ALL 过滤条件，只撤销该 API Key 下的订单
+	State    string   `json:"state,omitempty"`     // SET_MARKET_STATE
+	Reason   string   `json:"reason,omitempty"`    // SET_MARKET_STATE
+	Until    int64    `json:"until,omitempty"`     // SET_MARKET_STATE 自动结束的时间（毫秒），见 MarketState.Until
+	// SessionID CANCEL_ALL 过滤条件，只撤销带该会话 ID 的订单，见 Order.SessionID
+	SessionID string `json:"session_id,omitempty"`
+	// RequestID 非空时，处理结果写入 command_results:<RequestID> 供请求方等待
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// CommandResult 引擎指令的处理结果
+type CommandResult struct {
+	RequestID        string       `json:"request_id"`
+	CanceledOrderIDs []string     `json:"canceled_order_ids"`
+	MarketState      *MarketState `json:"market_state,omitempty"` // SET_MARKET_STATE 修改后的状态
+	Error            string       `json:"error,omitempty"`
+}
+
+// processCommand 处理一条引擎指令
+func processCommand(rc *RedisClient, pc *PostgresClient, pair string, cmd EngineCommand) {
+	// 集合竞价期间订单簿变化后按固定频率推送参考成交价
+	defer func() {
+		if currentMarketState(pair).State == MarketAuction {
+			markAuctionDirty(pair)
+		}
+	}()
+	switch cmd.Type {
+	case CommandNew:
+		if cmd.Order == nil {
+			log.Printf("NEW 指令缺少订单")
+			return
+		}
+		processNewOrder(rc, pair, *cmd.Order)
+	case CommandNewBatch:
+		log.Printf("处理批量订单: %d 笔", len(cmd.Orders))
+		for _, order := range cmd.Orders {
+			processNewOrder(rc, pair, order)
+		}
+	case CommandCancel:
+		processCancel(rc, pc, cmd.OrderID, cmd.UserID)
+	case CommandCancelBatch:
+		log.Printf("处理批量撤单: %d 笔", len(cmd.OrderIDs))
+		seen := make(map[string]bool, len(cmd.OrderIDs))
+		for _, orderID := range cmd.OrderIDs {
+			if seen[orderID] {
+				continue
+			}
+			seen[orderID] = true
+			processCancel(rc, pc, orderID, cmd.UserID)
+		}
+	case CommandReplace:
+		if cmd.Order == nil {
+			log.Printf("REPLACE 指令缺少订单")
+			return
+		}
+		log.Printf("处理改单: %s -> %s", cmd.OrderID, cmd.Order.OrderID)
+		if err := cancelOrder(rc, pc, cmd.OrderID, cmd.UserID); err != nil {
+			log.Printf("改单撤销原订单失败: %v", err)
+			rejectOrder(rc, pair, *cmd.Order, err.Error())
+			return
+		}
+		processNewOrder(rc, pair, *cmd.Order)
+	case CommandCancelAll:
+		log.Printf("处理全部撤单: 用户 %d, 交易对 %q, 方向 %q", cmd.UserID, cmd.Pair, cmd.Side)
+		result := CommandResult{RequestID: cmd.RequestID, CanceledOrderIDs: []string{}}
+		canceled, err := cancelAllOrders(rc, cmd.UserID, orderFilter{APIKey: cmd.APIKey, SessionID: cmd.SessionID}, cmd.Pair, cmd.Side)
+		if err != nil {
+			log.Printf("全部撤单失败: %v", err)
+			result.Error = err.Error()
+		} else {
+			result.CanceledOrderIDs = canceled
+		}
+		if cmd.RequestID != "" {
+			if err := rc.PublishCommandResult(result); err != nil {
+				log.Printf("发布指令结果失败: %v", err)
+			}
+		}
+	case CommandSetMarketState:
+		result := CommandResult{RequestID: cmd.RequestID}
+		state := currentMarketState(cmd.Pair)
+		if state.State == MarketAuction && cmd.State != MarketAuction {
+			// 结束集合竞价前先按统一价格撮合
+			if err := uncrossAuction(rc, cmd.Pair); err != nil {
+				log.Printf("集合竞价撮合失败: %v", err)
+				result.Error = err.Error()
+			}
+		}
+		if result.Error == "" {
+			state = setMarketState(rc, cmd.Pair, cmd.State, cmd.Reason, cmd.Until)
+		}
+		result.MarketState = &state
+		if cmd.RequestID != "" {
+			if err := rc.PublishCommandResult(result); err != nil {
+				log.Printf("发布指令结果失败: %v", err)
+			}
+		}
+	case CommandResumeMarket:
+		state := currentMarketState(cmd.Pair)
+		if state.Until == 0 || time.Now().UnixMilli() < state.Until {
+			break
+		}
+		switch state.State {
+		case MarketAuction:
+			if err := uncrossAuction(rc, cmd.Pair); err != nil {
+				log.Printf("集合竞价撮合失败: %v", err)
+				break
+			}
+			setMarketState(rc, cmd.Pair, MarketTrading, "集合竞价结束", 0)
+		case MarketHalted:
+			// 熔断结束后按配置先进行集合竞价
+			if market, _ := getMarket(cmd.Pair); market.ReopenAuction > 0 {
+				until := time.Now().Add(time.Duration(market.ReopenAuction) * time.Second).UnixMilli()
+				setMarketState(rc, cmd.Pair, MarketAuction, "熔断结束，集合竞价", until)
+			} else {
+				setMarketState(rc, cmd.Pair, MarketTrading, "熔断结束", 0)
+			}
+		default:
+			setMarketState(rc, cmd.Pair, MarketTrading, "到期恢复", 0)
+		}
+	default:
+		log.Printf("未知的引擎指令: %s", cmd.Type)
+	}
+}
+
+// processNewOrder 撮合一笔新订单
+func processNewOrder(rc *RedisClient, pair string, order Order) {
+	// 时间优先只看撮合引擎的到达顺序，不使用客户端填写的时间戳
+	order.ReceivedAt = time.Now().UnixNano()
+	sequence, err := rc.NextSequence(pair)
+	if err != nil {
+		log.Printf("分配订单序号失败: %v", err)
+		rejectOrder(rc, pair, order, "分配订单序号失败")
+		return
+	}
+	order.Sequence = sequence
+	log.Printf("处理订单: %+v", order)
+	if reason := engineRejectReason(rc, pair, order); reason != "" {
+		rejectOrder(rc, pair, order, reason)
+		return
+	}
+	remaining := order.Amount
+	if order.QuoteAmount != nil {
+		remaining = *order.QuoteAmount
+	}
+	events := &orderEventRecorder{pair: pair}
+	events.setSequence(order)
+	events.orders = append(events.orders, newOrderEvent(order, pair, "OPEN", remaining))
+	if err := events.commit(rc); err != nil {
+		log.Printf("写入订单事件失败: %v", err)
+	}
+	if order.OrderKind == "MARKET" {
+		err = matchOrdersMarket(rc, pair, order)
+	} else {
+		err = matchOrdersPriceLimit(rc, pair, order)
+	}
+	if err != nil {
+		log.Printf("撮合订单失败: %v", err)
+	}
+}
+
+// rejectOrder 已落库的订单被撮合引擎拒绝，标记为 REJECTED 并发布事件
+func rejectOrder(rc *RedisClient, pair string, order Order, reason string) {
+	events := &orderEventRecorder{pair: pair}
+	if order.Sequence != 0 {
+		events.setSequence(order)
+	}
+	events.setOrderStatus(order, "REJECTED", decimal.Zero, nil)
+	events.orders[len(events.orders)-1].Reason = reason
+	if err := events.commit(rc); err != nil {
+		log.Printf("更新订单状态失败: %v", err)
+	}
+}
+
+// processCancel 撤销一笔订单
+func processCancel(rc *RedisClient, pc *PostgresClient, orderID string, userID int) {
+	log.Printf("处理撤单: %s", orderID)
+	if err := cancelOrder(rc, pc, orderID, userID); err != nil {
+		log.Printf("撤单失败: %v", err)
+	}
+}
+
+// cancelOrder 从订单簿移除挂单并将状态更新为 CANCELED
+func cancelOrder(rc *RedisClient, pc *PostgresClient, orderID string, userID int) error {
+	model, err := pc.GetOrder(orderID)
+	if err != nil {
+		return err
+	}
+	if model.UserID != userID {
+		return fmt.Errorf("订单 %s 不属于用户 %d", orderID, userID)
+	}
+	if !isOpenStatus(model.Status) {
+		return fmt.Errorf("订单 %s 已结束，状态: %s", orderID, model.Status)
+	}
+
+	redisKey := "bids:" + model.Pair
+	if model.OrderType == "ASK" {
+		redisKey = "asks:" + model.Pair
+	}
+	order, err := rc.FindOrder(redisKey, orderID)
+	if err != nil {
+		return err
+	}
+	if order == nil {
+		return fmt.Errorf("订单 %s 不在订单簿中", orderID)
+	}
+
+	events := &orderEventRecorder{pair: model.Pair}
+	return events.run(rc, func() error {
+		events.setOrderStatus(*order, "CANCELED", order.Amount, nil)
+		if err := events.removeOrder(rc, redisKey, orderID); err != nil {
+			log.Printf("移除撤单订单失败: %v", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// cancelAllOrders 撤销用户在订单簿中的全部挂单，filter、pair 和 side 为空时不过滤。
+// 按用户、API Key 或会话的挂单索引查找订单，不扫描订单簿。
+// 全部订单在一个脚本中移出订单簿，状态变化作为一条持久化日志同时写入，由 runPersister 在一个事务中更新。
+// 返回被撤销的订单 ID；失败时没有订单被撤销，返回空列表
+func cancelAllOrders(rc *RedisClient, userID int, filter orderFilter, pair, side string) ([]string, error) {
+	found, err := rc.FindOrdersByUser(userID, filter)
+	if err != nil {
+		return []string{}, err
+	}
+
+	// 按交易对和 redisKey 排序，撤单顺序和返回的订单 ID 顺序固定
+	redisKeys := make([]string, 0, len(found))
+	for redisKey := range found {
+		s, p, _ := strings.Cut(redisKey, ":")
+		if (pair != "" && p != pair) || (side == "BID" && s != "bids") || (side == "ASK" && s != "asks") {
+			continue
+		}
+		redisKeys = append(redisKeys, redisKey)
+	}
+	sort.Slice(redisKeys, func(i, j int) bool {
+		_, pi, _ := strings.Cut(redisKeys[i], ":")
+		_, pj, _ := strings.Cut(redisKeys[j], ":")
+		if pi != pj {
+			return pi < pj
+		}
+		return redisKeys[i] < redisKeys[j]
+	})
+	if len(redisKeys) == 0 {
+		return []string{}, nil
+	}
+
+	events := &orderEventRecorder{}
+	var removals []BookRemoval
+	for _, redisKey := range redisKeys {
+		// 订单事件的交易对取自 recorder，按 redisKey 所在的交易对分别记录
+		_, events.pair, _ = strings.Cut(redisKey, ":")
+		events.setOrdersStatus(found[redisKey], "CANCELED")
+		for _, order := range found[redisKey] {
+			removals = append(removals, BookRemoval{RedisKey: redisKey, Order: order})
+		}
+	}
+	if err := events.removeOrders(rc, removals); err != nil {
+		log.Printf("移除撤单订单失败: %v", err)
+		return []string{}, err
+	}
+	canceled := make([]string, len(removals))
+	for i, removal := range removals {
+		canceled[i] = removal.Order.OrderID
+	}
+	return canceled, nil
+}
+
+// isOpenStatus 订单是否仍在订单簿中
+func isOpenStatus(status string) bool {
+	return status == "OPEN" || status == "PARTIALLY_FILLED"
+}
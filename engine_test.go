@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// addTestOrders 把订单作为数量为 1 的限价单挂到 pair 的订单簿
+func addTestOrders(t *testing.T, rc *RedisClient, pair string, orders ...Order) {
+	t.Helper()
+	for _, order := range orders {
+		order.OrderKind = "LIMIT"
+		order.Amount = decimal.NewFromInt(1)
+		if err := rc.AddOrderToBook(order, pair); err != nil {
+			t.Fatalf("AddOrderToBook(%s) 返回错误: %v", order.OrderID, err)
+		}
+	}
+}
+
+// drainPersistQueue 取出 persistQueue 中的全部记录
+func drainPersistQueue() []journalRecord {
+	var records []journalRecord
+	for {
+		select {
+		case record := <-persistQueue:
+			records = append(records, record)
+		default:
+			return records
+		}
+	}
+}
+
+func TestCancelAllOrders(t *testing.T) {
+	rc := newTestRedisClient(t)
+	drainPersistQueue()
+	addTestOrders(t, rc, "BTC_USDT",
+		Order{OrderID: "a", UserID: 1, OrderType: "BID", Price: decimal.NewFromInt(9), Sequence: 1},
+		Order{OrderID: "b", UserID: 1, OrderType: "ASK", Price: decimal.NewFromInt(11), Sequence: 2},
+		Order{OrderID: "c", UserID: 1, OrderType: "BID", Price: decimal.NewFromInt(9), Sequence: 3},
+		Order{OrderID: "d", UserID: 2, OrderType: "BID", Price: decimal.NewFromInt(9), Sequence: 4},
+	)
+	addTestOrders(t, rc, "ETH_USDT", Order{OrderID: "e", UserID: 1, OrderType: "ASK", Price: decimal.NewFromInt(5), Sequence: 1})
+
+	canceled, err := cancelAllOrders(rc, 1, orderFilter{}, "", "")
+	if err != nil {
+		t.Fatalf("cancelAllOrders 返回错误: %v", err)
+	}
+	if got := strings.Join(canceled, ","); got != "b,a,c,e" {
+		t.Errorf("撤销的订单 = %s, 期望 b,a,c,e", got)
+	}
+
+	// 全部订单在一条日志中，订单事件带各自的交易对
+	stream := rc.client.XRange(rc.ctx, journalStream, "-", "+").Val()
+	if len(stream) != 1 {
+		t.Fatalf("持久化日志有 %d 条, 期望 1 条", len(stream))
+	}
+	var entry journalEntry
+	if err := json.Unmarshal([]byte(stream[0].Values["entry"].(string)), &entry); err != nil {
+		t.Fatalf("解析日志失败: %v", err)
+	}
+	var updates []string
+	for _, update := range entry.Orders {
+		updates = append(updates, update.OrderID+":"+update.Status)
+	}
+	if got := strings.Join(updates, ","); got != "b:CANCELED,a:CANCELED,c:CANCELED,e:CANCELED" {
+		t.Errorf("日志中的订单状态 = %s", got)
+	}
+	var pairs []string
+	for _, message := range entry.Messages {
+		var event OrderEvent
+		if err := json.Unmarshal(message.Payload, &event); err != nil {
+			t.Fatalf("解析订单事件失败: %v", err)
+		}
+		pairs = append(pairs, event.OrderID+":"+event.Pair)
+	}
+	if got := strings.Join(pairs, ","); got != "b:BTC_USDT,a:BTC_USDT,c:BTC_USDT,e:ETH_USDT" {
+		t.Errorf("订单事件的交易对 = %s", got)
+	}
+	if records := drainPersistQueue(); len(records) != 1 || records[0].ID != stream[0].ID {
+		t.Errorf("persistQueue 中有 %d 条记录, 期望日志 %s", len(records), stream[0].ID)
+	}
+
+	if orders, _ := rc.GetAllOrders("bids:BTC_USDT"); len(orders) != 1 || orders[0].OrderID != "d" {
+		t.Errorf("买盘剩余订单 %v, 期望只有 d", orders)
+	}
+	if n := rc.client.Exists(rc.ctx, bookLevelsKey("asks:BTC_USDT"), bookLevelsKey("asks:ETH_USDT"), userOrdersKey(1)).Val(); n != 0 {
+		t.Errorf("撤单后仍有 %d 个价位或索引键", n)
+	}
+}
+
+func TestCancelAllOrdersFilter(t *testing.T) {
+	rc := newTestRedisClient(t)
+	drainPersistQueue()
+	addTestOrders(t, rc, "BTC_USDT",
+		Order{OrderID: "a", UserID: 1, OrderType: "BID", Price: decimal.NewFromInt(9), Sequence: 1},
+		Order{OrderID: "b", UserID: 1, OrderType: "ASK", Price: decimal.NewFromInt(11), Sequence: 2},
+	)
+	addTestOrders(t, rc, "ETH_USDT", Order{OrderID: "c", UserID: 1, OrderType: "BID", Price: decimal.NewFromInt(5), Sequence: 1})
+
+	canceled, err := cancelAllOrders(rc, 1, orderFilter{}, "BTC_USDT", "BID")
+	if err != nil || strings.Join(canceled, ",") != "a" {
+		t.Fatalf("cancelAllOrders = %v, %v, 期望 [a]", canceled, err)
+	}
+	drainPersistQueue()
+}
+
+func TestCancelAllOrdersConflict(t *testing.T) {
+	rc := newTestRedisClient(t)
+	drainPersistQueue()
+	addTestOrders(t, rc, "BTC_USDT",
+		Order{OrderID: "a", UserID: 1, OrderType: "BID", Price: decimal.NewFromInt(9), Sequence: 1},
+		Order{OrderID: "b", UserID: 1, OrderType: "BID", Price: decimal.NewFromInt(8), Sequence: 2},
+	)
+
+	// 读取后、移除前订单被修改，全部订单保留，不写日志
+	found, err := rc.FindOrdersByUser(1, orderFilter{})
+	if err != nil {
+		t.Fatalf("FindOrdersByUser 返回错误: %v", err)
+	}
+	rc.client.HSet(rc.ctx, bookOrderKey("b"), "amount", "0.5")
+	var removals []BookRemoval
+	for _, order := range found["bids:BTC_USDT"] {
+		removals = append(removals, BookRemoval{RedisKey: "bids:BTC_USDT", Order: order})
+	}
+	if _, err := rc.RemoveOrders(removals, journalEntry{Orders: []orderUpdate{{OrderID: "a", Status: "CANCELED"}}}); err == nil {
+		t.Fatal("RemoveOrders 应返回错误")
+	}
+	if orders, _ := rc.GetAllOrders("bids:BTC_USDT"); len(orders) != 2 {
+		t.Errorf("冲突时不应移除订单, 剩余 %d 笔", len(orders))
+	}
+	if n := rc.client.XLen(rc.ctx, journalStream).Val(); n != 0 {
+		t.Errorf("冲突时不应写日志, 现有 %d 条", n)
+	}
+}
@@ -0,0 +1,985 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultFIXAddr   = ":9878"
+	defaultFIXCompID = "MATCHENGINE"
+	fixLogonTimeout  = 10 * time.Second // 连接后必须在此时间内完成登录
+	fixWriteWait     = 5 * time.Second
+	fixSendQueueSize = 256 // 每个会话的发送队列长度
+	maxFIXHeartBtInt = 300 // 最大心跳间隔（秒）
+)
+
+// FIX 枚举值与订单字段的对应关系
+var (
+	fixSides    = map[string]string{"1": "BID", "2": "ASK"}
+	fixOrdTypes = map[string]string{"1": "MARKET", "2": "LIMIT"}
+	// fixOrdStatuses orders 表状态到 OrdStatus(39) 的映射
+	fixOrdStatuses = map[string]string{
+		"OPEN":             "0",
+		"PARTIALLY_FILLED": "1",
+		"FILLED":           "2",
+		"CANCELED":         "4",
+		"CLOSE":            "C",
+		"REJECTED":         "8",
+	}
+)
+
+// getFIXAddr 返回 FIX 网关监听地址，可通过环境变量 FIX_ADDR 配置
+func getFIXAddr() string {
+	if addr := os.Getenv("FIX_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultFIXAddr
+}
+
+// signFIXLogon 计算 Logon 签名，放在 Password(554) 中：
+// hex(HMAC-SHA256(secret, SendingTime + MsgSeqNum + SenderCompID + TargetCompID))
+func signFIXLogon(secret, sendingTime, seqNum, senderCompID, targetCompID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(sendingTime + seqNum + senderCompID + targetCompID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fixGateway FIX 4.4 接入网关。每个 TCP 连接是一个会话，
+// 以 Username(553) 中的 API Key 登录，执行报告由 order_events 驱动
+type fixGateway struct {
+	rc     *RedisClient
+	pc     *PostgresClient
+	compID string
+
+	mu       sync.Mutex
+	sessions map[*fixSession]struct{}
+}
+
+func newFIXGateway(rc *RedisClient, pc *PostgresClient) *fixGateway {
+	compID := os.Getenv("FIX_COMP_ID")
+	if compID == "" {
+		compID = defaultFIXCompID
+	}
+	return &fixGateway{rc: rc, pc: pc, compID: compID, sessions: make(map[*fixSession]struct{})}
+}
+
+// ListenAndServe 监听 FIX 连接
+func (g *fixGateway) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("FIX 网关启动在 %s，CompID %s", addr, g.compID)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go g.serve(conn)
+	}
+}
+
+func (g *fixGateway) serve(conn net.Conn) {
+	s := &fixSession{
+		gw:     g,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		out:    make(chan fixOutbound, fixSendQueueSize),
+		events: make(chan OrderEvent, fixSendQueueSize),
+		done:   make(chan struct{}),
+		orders: make(map[string]*fixOrderState),
+	}
+	defer conn.Close()
+
+	// 登录完成前只有当前 goroutine 写连接，登录回复直接发送
+	conn.SetReadDeadline(time.Now().Add(fixLogonTimeout))
+	msg, err := readFIXMessage(s.reader)
+	if err != nil {
+		log.Printf("FIX 连接 %s 读取登录消息失败: %v", conn.RemoteAddr(), err)
+		return
+	}
+	reply, err := s.logon(msg)
+	if err != nil {
+		log.Printf("FIX 连接 %s 登录失败: %v", conn.RemoteAddr(), err)
+		if s.clientCompID != "" {
+			s.writeNext(newFIXMessage(fixMsgLogout).Set(tagText, err.Error()))
+		}
+		return
+	}
+	if err := s.writeNext(reply); err != nil {
+		log.Printf("FIX 连接 %s 发送 Logon 失败: %v", conn.RemoteAddr(), err)
+		return
+	}
+	log.Printf("FIX 会话登录: %s, API Key %s, 用户 %d", s.clientCompID, s.principal.APIKey, s.principal.UserID)
+
+	g.mu.Lock()
+	g.sessions[s] = struct{}{}
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.sessions, s)
+		g.mu.Unlock()
+		log.Printf("FIX 会话断开: %s", s.clientCompID)
+	}()
+
+	// 读循环结束后关闭会话，等待 writePump 发出队列中剩余的消息（如 Logout）
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.writePump()
+	}()
+	go s.eventLoop()
+	defer func() {
+		s.close()
+		<-stopped
+	}()
+	s.readLoop()
+}
+
+// onOrderEvent 把订单事件转发给同一 API Key 登录的会话。事件只投递到各会话的事件队列，
+// 查询数据库和生成执行报告在会话自己的 goroutine 中进行，慢会话不会阻塞其他会话和同一订阅上的 WebSocket 推送
+func (g *fixGateway) onOrderEvent(event OrderEvent) {
+	if event.APIKey == "" {
+		return
+	}
+	g.mu.Lock()
+	var targets []*fixSession
+	for s := range g.sessions {
+		if s.principal.APIKey == event.APIKey {
+			targets = append(targets, s)
+		}
+	}
+	g.mu.Unlock()
+	for _, s := range targets {
+		s.deliver(event)
+	}
+}
+
+// fixOrderState 会话跟踪的订单信息，用于填充执行报告
+type fixOrderState struct {
+	OrderID       string
+	ClOrdID       string
+	OrigOrderID   string // 改单生成的新订单对应的原订单
+	OrigClOrdID   string
+	CancelClOrdID string // 撤单请求的 ClOrdID
+	Replacing     bool   // 正在被改单替换，撤销时不单独回报
+	Symbol        string
+	Side          string
+	OrderQty      decimal.Decimal
+	Price         decimal.Decimal
+	CumQty        decimal.Decimal
+	CumNotional   decimal.Decimal
+	OrdStatus     string              // 最近一次回报的 OrdStatus
+	Done          bool                // 已结束，正在被改单替换时保留到改单结果回报
+	Counted       map[string]struct{} // 从数据库加载时已计入 CumQty 的成交，收到对应事件时不再累加
+}
+
+// newFIXOrderState 按数据库中的订单和成交创建跟踪信息，用于本会话没有跟踪的订单
+func newFIXOrderState(model *OrderModel, trades []TradeModel) *fixOrderState {
+	state := &fixOrderState{
+		OrderID:   model.OrderID,
+		Symbol:    model.Pair,
+		Side:      fixSide(model.OrderType),
+		OrderQty:  decimal.NewFromFloat(model.Amount),
+		Price:     decimal.NewFromFloat(model.Price),
+		OrdStatus: fixOrdStatuses[model.Status],
+		Counted:   make(map[string]struct{}, len(trades)),
+	}
+	if model.ClientOrderID != nil {
+		state.ClOrdID = *model.ClientOrderID
+	}
+	for _, trade := range trades {
+		amount := decimal.NewFromFloat(trade.Amount)
+		state.CumQty = state.CumQty.Add(amount)
+		state.CumNotional = state.CumNotional.Add(decimal.NewFromFloat(trade.Price).Mul(amount))
+		state.Counted[trade.TradeID] = struct{}{}
+	}
+	return state
+}
+
+// fixSide 订单方向转换为 Side(54)
+func fixSide(orderType string) string {
+	if orderType == "ASK" {
+		return "2"
+	}
+	return "1"
+}
+
+// fixOutbound 发送队列中的一条消息。gapFillFrom 非零时 msg 为 GapFill，
+// 以该序号发送，NewSeqNo 由 writePump 按当时已发出的序号填写
+type fixOutbound struct {
+	msg         *fixMessage
+	gapFillFrom int
+}
+
+// fixSession 一个已登录的 FIX 会话。会话状态不跨连接保存，
+// 每次登录以 Logon 的 MsgSeqNum 为起点，服务端发出的序号从 1 开始。
+// 登录后所有写操作由 writePump 完成，其他 goroutine 只向 out 队列投递消息
+type fixSession struct {
+	gw           *fixGateway
+	conn         net.Conn
+	reader       *bufio.Reader
+	principal    *Principal
+	clientCompID string
+	heartBtInt   time.Duration
+
+	out       chan fixOutbound // 待发送消息
+	events    chan OrderEvent  // 待处理的订单事件，由 eventLoop 串行处理
+	done      chan struct{}    // 会话结束后关闭
+	closeOnce sync.Once
+
+	// 登录前由 serve、登录后只由 writePump 访问
+	outSeq   int
+	lastSent time.Time
+
+	inSeq    int // 期望收到的下一个序号，只在读循环中访问
+	resendTo int // 已请求重发的最大序号，0 表示没有未完成的重发请求
+
+	ordersMu sync.Mutex
+	orders   map[string]*fixOrderState
+}
+
+// logon 校验 Logon 消息，返回回复的 Logon
+func (s *fixSession) logon(msg *fixMessage) (*fixMessage, error) {
+	s.clientCompID = msg.Get(tagSenderCompID)
+	if msg.MsgType() != fixMsgLogon {
+		return nil, errors.New("第一条消息必须是 Logon")
+	}
+	if s.clientCompID == "" || msg.Get(tagTargetCompID) != s.gw.compID {
+		return nil, errors.New("SenderCompID 或 TargetCompID 无效")
+	}
+	seq := msg.SeqNum()
+	if seq <= 0 {
+		return nil, errors.New("无效的 MsgSeqNum")
+	}
+	heartBtInt, err := strconv.Atoi(msg.Get(tagHeartBtInt))
+	if err != nil || heartBtInt <= 0 || heartBtInt > maxFIXHeartBtInt {
+		return nil, fmt.Errorf("HeartBtInt 必须在 1 到 %d 之间", maxFIXHeartBtInt)
+	}
+	if msg.Get(tagEncryptMethod) != "0" {
+		return nil, errors.New("EncryptMethod 必须是 0")
+	}
+
+	// 校验时间窗口和签名
+	sendingTime, err := time.Parse(fixTimeLayout, msg.Get(tagSendingTime))
+	if err != nil {
+		return nil, errors.New("无效的 SendingTime")
+	}
+	if skew := time.Since(sendingTime); skew > maxRecvWindow*time.Millisecond || skew < -maxRecvWindow*time.Millisecond {
+		return nil, errors.New("SendingTime 超出允许范围")
+	}
+	key, err := s.gw.pc.GetAPIKey(msg.Get(tagUsername))
+	if err != nil || !key.Enabled {
+		return nil, errors.New("API Key 无效")
+	}
+	expected := signFIXLogon(key.Secret, msg.Get(tagSendingTime), msg.Get(tagMsgSeqNum), s.clientCompID, s.gw.compID)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(msg.Get(tagPassword)))) {
+		return nil, errors.New("签名错误")
+	}
+	s.principal = newPrincipal(key)
+	if !s.principal.HasScope(ScopeTrade) {
+		return nil, errors.New("API Key 没有 trade 权限")
+	}
+
+	s.heartBtInt = time.Duration(heartBtInt) * time.Second
+	s.inSeq = seq + 1
+	reply := newFIXMessage(fixMsgLogon).
+		Set(tagEncryptMethod, "0").
+		Set(tagHeartBtInt, strconv.Itoa(heartBtInt))
+	if msg.Get(tagResetSeqNumFlag) == "Y" {
+		reply.Set(tagResetSeqNumFlag, "Y")
+	}
+	return reply, nil
+}
+
+// send 非阻塞地投递消息到发送队列，由 writePump 填充标准头、分配序号后发送。
+// 队列已满说明对端消费过慢，直接断开连接，错过的执行报告需通过 HTTP 查询
+func (s *fixSession) send(msg *fixMessage) error {
+	return s.enqueue(fixOutbound{msg: msg})
+}
+
+func (s *fixSession) enqueue(out fixOutbound) error {
+	select {
+	case <-s.done:
+		return errors.New("会话已关闭")
+	default:
+	}
+	select {
+	case s.out <- out:
+		return nil
+	default:
+		log.Printf("FIX 会话 %s 发送队列已满，断开连接", s.clientCompID)
+		s.conn.Close()
+		return errors.New("发送队列已满")
+	}
+}
+
+// close 结束会话，writePump 发出队列中剩余的消息后退出，可重复调用
+func (s *fixSession) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// writePump 串行发送队列中的消息，在 HeartBtInt 内没有发出消息时发送 Heartbeat
+func (s *fixSession) writePump() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case out := <-s.out:
+			if err := s.writeOutbound(out); err != nil {
+				log.Printf("FIX 会话 %s 发送失败: %v", s.clientCompID, err)
+				s.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if time.Since(s.lastSent) >= s.heartBtInt {
+				if err := s.writeNext(newFIXMessage(fixMsgHeartbeat)); err != nil {
+					log.Printf("发送 FIX Heartbeat 失败: %v", err)
+					s.conn.Close()
+					return
+				}
+			}
+		case <-s.done:
+			for {
+				select {
+				case out := <-s.out:
+					if s.writeOutbound(out) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeOutbound 发送队列中的一条消息
+func (s *fixSession) writeOutbound(out fixOutbound) error {
+	if out.gapFillFrom == 0 {
+		return s.writeNext(out.msg)
+	}
+	if out.gapFillFrom > s.outSeq {
+		return nil
+	}
+	out.msg.Set(tagNewSeqNo, strconv.Itoa(s.outSeq+1))
+	return s.write(out.msg, out.gapFillFrom)
+}
+
+// writeNext 以下一个序号发送消息
+func (s *fixSession) writeNext(msg *fixMessage) error {
+	s.outSeq++
+	return s.write(msg, s.outSeq)
+}
+
+// write 以指定序号发送消息，只能在拥有连接写入的 goroutine 中调用
+func (s *fixSession) write(msg *fixMessage, seq int) error {
+	msg.Set(tagSenderCompID, s.gw.compID).
+		Set(tagTargetCompID, s.clientCompID).
+		Set(tagMsgSeqNum, strconv.Itoa(seq)).
+		Set(tagSendingTime, time.Now().UTC().Format(fixTimeLayout))
+	s.conn.SetWriteDeadline(time.Now().Add(fixWriteWait))
+	if _, err := s.conn.Write(msg.encode()); err != nil {
+		return err
+	}
+	s.lastSent = time.Now()
+	return nil
+}
+
+func (s *fixSession) sendLogout(text string) {
+	if err := s.send(newFIXMessage(fixMsgLogout).Set(tagText, text)); err != nil {
+		log.Printf("发送 FIX Logout 失败: %v", err)
+	}
+}
+
+// reject 发送会话层 Reject(3)
+func (s *fixSession) reject(ref *fixMessage, reason int, text string) {
+	msg := newFIXMessage(fixMsgReject).
+		Set(tagRefSeqNum, ref.Get(tagMsgSeqNum)).
+		Set(tagRefMsgType, ref.MsgType()).
+		Set(tagSessionRejectReason, strconv.Itoa(reason)).
+		Set(tagText, text)
+	if err := s.send(msg); err != nil {
+		log.Printf("发送 FIX Reject 失败: %v", err)
+	}
+}
+
+// readLoop 读取并处理消息。超过 HeartBtInt 没有收到消息时发送 TestRequest，
+// 之后仍无响应则断开连接
+func (s *fixSession) readLoop() {
+	testReqSent := false
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(s.heartBtInt + s.heartBtInt/5))
+		msg, err := readFIXMessage(s.reader)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if !testReqSent {
+					testReqSent = true
+					s.send(newFIXMessage(fixMsgTestRequest).Set(tagTestReqID, strconv.FormatInt(time.Now().UnixMilli(), 10)))
+					continue
+				}
+				s.sendLogout("心跳超时")
+			}
+			log.Printf("FIX 会话 %s 读取失败: %v", s.clientCompID, err)
+			return
+		}
+		testReqSent = false
+		if !s.handle(msg) {
+			return
+		}
+	}
+}
+
+// handle 校验序号并分发消息，返回 false 时断开连接
+func (s *fixSession) handle(msg *fixMessage) bool {
+	if msg.Get(tagSenderCompID) != s.clientCompID || msg.Get(tagTargetCompID) != s.gw.compID {
+		s.sendLogout("CompID 不匹配")
+		return false
+	}
+
+	// Reset 模式的 SequenceReset 不校验序号
+	if msg.MsgType() == fixMsgSequenceReset && msg.Get(tagGapFillFlag) != "Y" {
+		s.resetInSeq(msg)
+		return true
+	}
+
+	seq := msg.SeqNum()
+	switch {
+	case seq <= 0:
+		s.sendLogout("缺少 MsgSeqNum")
+		return false
+	case seq < s.inSeq:
+		if msg.Get(tagPossDupFlag) == "Y" {
+			return true // 已处理过的重发消息
+		}
+		s.sendLogout(fmt.Sprintf("MsgSeqNum 过低，期望 %d，收到 %d", s.inSeq, seq))
+		return false
+	case seq > s.inSeq:
+		// 出现缺口时丢弃后续消息并请求重发，缺口补齐前不重复请求
+		if msg.MsgType() == fixMsgLogout {
+			s.send(newFIXMessage(fixMsgLogout))
+			return false
+		}
+		if s.resendTo == 0 {
+			s.resendTo = seq
+			s.send(newFIXMessage(fixMsgResendRequest).
+				Set(tagBeginSeqNo, strconv.Itoa(s.inSeq)).
+				Set(tagEndSeqNo, "0"))
+		}
+		return true
+	}
+	s.inSeq = seq + 1
+	if s.resendTo != 0 && s.inSeq > s.resendTo {
+		s.resendTo = 0
+	}
+
+	switch msg.MsgType() {
+	case fixMsgHeartbeat, fixMsgReject:
+	case fixMsgTestRequest:
+		s.send(newFIXMessage(fixMsgHeartbeat).Set(tagTestReqID, msg.Get(tagTestReqID)))
+	case fixMsgResendRequest:
+		s.handleResendRequest(msg)
+	case fixMsgSequenceReset:
+		s.resetInSeq(msg)
+	case fixMsgLogout:
+		s.send(newFIXMessage(fixMsgLogout))
+		return false
+	case fixMsgLogon:
+		s.reject(msg, 99, "会话已登录")
+	case fixMsgNewOrderSingle:
+		s.handleNewOrder(msg)
+	case fixMsgOrderCancelRequest:
+		s.handleCancel(msg)
+	case fixMsgOrderCancelReplaceRequest:
+		s.handleReplace(msg)
+	default:
+		s.reject(msg, 11, "不支持的消息类型")
+	}
+	return true
+}
+
+// resetInSeq 处理 SequenceReset(4)，只允许增大期望序号
+func (s *fixSession) resetInSeq(msg *fixMessage) {
+	newSeq, err := strconv.Atoi(msg.Get(tagNewSeqNo))
+	if err != nil || newSeq < s.inSeq {
+		s.reject(msg, 5, "无效的 NewSeqNo")
+		return
+	}
+	s.inSeq = newSeq
+	if s.resendTo != 0 && s.inSeq > s.resendTo {
+		s.resendTo = 0
+	}
+}
+
+// handleResendRequest 网关不保存已发送的消息，以 GapFill 跳过请求的全部区间。
+// 期间的执行报告不会重发，客户端应通过 HTTP 查询订单状态
+func (s *fixSession) handleResendRequest(msg *fixMessage) {
+	begin, err := strconv.Atoi(msg.Get(tagBeginSeqNo))
+	if err != nil || begin <= 0 {
+		s.reject(msg, 5, "无效的 BeginSeqNo")
+		return
+	}
+	gapFill := newFIXMessage(fixMsgSequenceReset).
+		Set(tagPossDupFlag, "Y").
+		Set(tagGapFillFlag, "Y")
+	if err := s.enqueue(fixOutbound{msg: gapFill, gapFillFrom: begin}); err != nil {
+		log.Printf("发送 FIX SequenceReset 失败: %v", err)
+	}
+}
+
+// parseOrder 把 NewOrderSingle 或 OrderCancelReplaceRequest 转换为订单
+func (s *fixSession) parseOrder(msg *fixMessage) (Order, error) {
+	order := Order{
+		ClientOrderID: msg.Get(tagClOrdID),
+		UserID:        s.principal.UserID,
+		APIKey:        s.principal.APIKey,
+	}
+	if order.ClientOrderID == "" {
+		return order, errors.New("缺少 ClOrdID")
+	}
+	var ok bool
+	if order.OrderType, ok = fixSides[msg.Get(tagSide)]; !ok {
+		return order, errors.New("无效的 Side，必须是 1 或 2")
+	}
+	if order.OrderKind, ok = fixOrdTypes[msg.Get(tagOrdType)]; !ok {
+		return order, errors.New("无效的 OrdType，必须是 1 或 2")
+	}
+	amount, err := decimal.NewFromString(msg.Get(tagOrderQty))
+	if err != nil {
+		return order, errors.New("无效的 OrderQty")
+	}
+	order.Amount = amount
+	if order.OrderKind == "LIMIT" {
+		price, err := decimal.NewFromString(msg.Get(tagPrice))
+		if err != nil {
+			return order, errors.New("无效的 Price")
+		}
+		order.Price = price
+	}
+	if err := validateOrder(msg.Get(tagSymbol), &order); err != nil {
+		return order, err
+	}
+	return order, nil
+}
+
+// saveOrder 保存新订单，重复的 ClOrdID 视为拒绝
+func (s *fixSession) saveOrder(order Order) error {
+	existing, err := saveNewOrder(s.gw.pc, order)
+	if existing != nil {
+		return errors.New("重复的 ClOrdID")
+	}
+	if err != nil && !errors.Is(err, errDuplicateOrderID) {
+		log.Printf("保存订单到数据库失败: %v", err)
+		return errors.New("保存订单失败")
+	}
+	return err
+}
+
+func (s *fixSession) track(state *fixOrderState) {
+	s.ordersMu.Lock()
+	s.orders[state.OrderID] = state
+	s.ordersMu.Unlock()
+}
+
+func (s *fixSession) untrack(orderID string) {
+	s.ordersMu.Lock()
+	delete(s.orders, orderID)
+	s.ordersMu.Unlock()
+}
+
+// handleNewOrder 处理 NewOrderSingle(D)，确认回报在撮合引擎接受订单后发送
+func (s *fixSession) handleNewOrder(msg *fixMessage) {
+	order, err := s.parseOrder(msg)
+	if err == nil {
+		err = s.saveOrder(order)
+	}
+	if err == nil {
+		if err = checkOrderEntry(s.gw.pc, s.gw.rc, msg.Get(tagSymbol), order); err != nil {
+			rejectOrders(s.gw.pc, order)
+		}
+	}
+	if err != nil {
+		s.sendOrderReject(msg, err.Error())
+		return
+	}
+
+	s.track(&fixOrderState{
+		OrderID:  order.OrderID,
+		ClOrdID:  order.ClientOrderID,
+		Symbol:   msg.Get(tagSymbol),
+		Side:     msg.Get(tagSide),
+		OrderQty: order.Amount,
+		Price:    order.Price,
+	})
+	if err := s.gw.rc.SubmitOrder(order); err != nil {
+		log.Printf("提交订单到 Redis 失败: %v", err)
+		rejectOrders(s.gw.pc, order)
+		s.untrack(order.OrderID)
+		s.sendOrderReject(msg, "提交订单失败")
+	}
+}
+
+// sendOrderReject 对无法受理的新订单发送 ExecType=8 的执行报告
+func (s *fixSession) sendOrderReject(msg *fixMessage, text string) {
+	report := newFIXMessage(fixMsgExecutionReport).
+		Set(tagOrderID, "NONE").
+		Set(tagClOrdID, msg.Get(tagClOrdID)).
+		Set(tagExecID, uuid.New().String()).
+		Set(tagExecType, "8").
+		Set(tagOrdStatus, "8").
+		Set(tagSymbol, msg.Get(tagSymbol)).
+		Set(tagSide, msg.Get(tagSide)).
+		Set(tagOrderQty, msg.Get(tagOrderQty)).
+		Set(tagLeavesQty, "0").
+		Set(tagCumQty, "0").
+		Set(tagAvgPx, "0").
+		Set(tagOrdRejReason, "0").
+		Set(tagText, text)
+	if text == "重复的 ClOrdID" {
+		report.Set(tagOrdRejReason, "6")
+	}
+	if err := s.send(report); err != nil {
+		log.Printf("发送 FIX 执行报告失败: %v", err)
+	}
+}
+
+// findOrder 按 OrigClOrdID(41) 或 OrderID(37) 查找当前用户的挂单，失败时返回 CxlRejReason 和原因
+func (s *fixSession) findOrder(msg *fixMessage) (*OrderModel, string, string) {
+	var model *OrderModel
+	var err error
+	if origClOrdID := msg.Get(tagOrigClOrdID); origClOrdID != "" {
+		model, err = s.gw.pc.GetOrderByClientOrderID(s.principal.UserID, origClOrdID)
+	} else if orderID := msg.Get(tagOrderID); orderID != "" {
+		if _, err := uuid.Parse(orderID); err != nil {
+			return nil, "1", "订单不存在"
+		}
+		model, err = s.gw.pc.GetOrder(orderID)
+	} else {
+		return nil, "1", "需要 OrigClOrdID 或 OrderID"
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && model.UserID != s.principal.UserID) {
+		return nil, "1", "订单不存在"
+	}
+	if err != nil {
+		log.Printf("查询订单失败: %v", err)
+		return nil, "99", "查询订单失败"
+	}
+	if !isOpenStatus(model.Status) {
+		return model, "0", "订单已结束，无法撤销"
+	}
+	return model, "", ""
+}
+
+// sendCancelReject 发送 OrderCancelReject(9)，responseTo 为 1（撤单）或 2（改单）
+func (s *fixSession) sendCancelReject(msg *fixMessage, model *OrderModel, responseTo, reason, text string) {
+	report := newFIXMessage(fixMsgOrderCancelReject).
+		Set(tagOrderID, "NONE").
+		Set(tagClOrdID, msg.Get(tagClOrdID)).
+		Set(tagOrigClOrdID, msg.Get(tagOrigClOrdID)).
+		Set(tagOrdStatus, "8").
+		Set(tagCxlRejResponseTo, responseTo).
+		Set(tagCxlRejReason, reason).
+		Set(tagText, text)
+	if model != nil {
+		report.Set(tagOrderID, model.OrderID).Set(tagOrdStatus, fixOrdStatuses[model.Status])
+	}
+	if err := s.send(report); err != nil {
+		log.Printf("发送 FIX 撤单拒绝失败: %v", err)
+	}
+}
+
+// loadState 返回订单的跟踪信息，不存在时按数据库中的订单和成交创建。查询期间不持有 ordersMu
+func (s *fixSession) loadState(model *OrderModel) (*fixOrderState, error) {
+	s.ordersMu.Lock()
+	state := s.orders[model.OrderID]
+	s.ordersMu.Unlock()
+	if state != nil {
+		return state, nil
+	}
+
+	trades, err := s.gw.pc.GetOrderTrades(model.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	s.ordersMu.Lock()
+	defer s.ordersMu.Unlock()
+	if state = s.orders[model.OrderID]; state == nil {
+		state = newFIXOrderState(model, trades)
+		s.orders[model.OrderID] = state
+	}
+	return state, nil
+}
+
+// handleCancel 处理 OrderCancelRequest(F)，撤单回报在撮合引擎撤销订单后发送
+func (s *fixSession) handleCancel(msg *fixMessage) {
+	model, reason, text := s.findOrder(msg)
+	if reason != "" {
+		s.sendCancelReject(msg, model, "1", reason, text)
+		return
+	}
+	if err := checkCancelState(model.Pair); err != nil {
+		s.sendCancelReject(msg, model, "1", "99", err.Error())
+		return
+	}
+
+	state, err := s.loadState(model)
+	if err != nil {
+		log.Printf("查询订单成交失败: %v", err)
+		s.sendCancelReject(msg, model, "1", "99", "查询订单失败")
+		return
+	}
+	s.ordersMu.Lock()
+	state.CancelClOrdID = msg.Get(tagClOrdID)
+	s.ordersMu.Unlock()
+	if err := s.gw.rc.SubmitCancel(model.OrderID, s.principal.UserID); err != nil {
+		log.Printf("提交撤单到 Redis 失败: %v", err)
+		s.sendCancelReject(msg, model, "1", "99", "提交撤单失败")
+	}
+}
+
+// handleReplace 处理 OrderCancelReplaceRequest(G)。撤销原订单剩余部分，
+// 以新的 ClOrdID 和 OrderQty 提交新订单，两步在撮合引擎中连续执行
+func (s *fixSession) handleReplace(msg *fixMessage) {
+	model, reason, text := s.findOrder(msg)
+	if reason != "" {
+		s.sendCancelReject(msg, model, "2", reason, text)
+		return
+	}
+	order, err := s.parseOrder(msg)
+	if err == nil && order.OrderType != model.OrderType {
+		err = errors.New("改单不能修改 Side")
+	}
+	if err == nil {
+		err = s.saveOrder(order)
+	}
+	// 原订单会被撤销，不计入挂单和敞口
+	if err == nil {
+		if err = checkOrderEntry(s.gw.pc, s.gw.rc, model.Pair, order, model.OrderID); err != nil {
+			rejectOrders(s.gw.pc, order)
+		}
+	}
+	if err != nil {
+		s.sendCancelReject(msg, model, "2", "99", err.Error())
+		return
+	}
+	orig, err := s.loadState(model)
+	if err != nil {
+		log.Printf("查询订单成交失败: %v", err)
+		rejectOrders(s.gw.pc, order)
+		s.sendCancelReject(msg, model, "2", "99", "查询订单失败")
+		return
+	}
+
+	s.ordersMu.Lock()
+	orig.Replacing = true
+	s.orders[order.OrderID] = &fixOrderState{
+		OrderID:     order.OrderID,
+		ClOrdID:     order.ClientOrderID,
+		OrigOrderID: model.OrderID,
+		OrigClOrdID: orig.ClOrdID,
+		Symbol:      msg.Get(tagSymbol),
+		Side:        msg.Get(tagSide),
+		OrderQty:    order.Amount,
+		Price:       order.Price,
+	}
+	s.ordersMu.Unlock()
+
+	cmd := EngineCommand{Type: CommandReplace, OrderID: model.OrderID, Order: &order, UserID: s.principal.UserID}
+	if err := s.gw.rc.SubmitCommand(cmd); err != nil {
+		log.Printf("提交改单到 Redis 失败: %v", err)
+		rejectOrders(s.gw.pc, order)
+		s.ordersMu.Lock()
+		orig.Replacing = false
+		delete(s.orders, order.OrderID)
+		s.ordersMu.Unlock()
+		s.sendCancelReject(msg, model, "2", "99", "提交改单失败")
+	}
+}
+
+// deliver 非阻塞地把订单事件投递到事件队列。队列已满说明会话处理过慢，直接断开连接，
+// 错过的执行报告需通过 HTTP 查询
+func (s *fixSession) deliver(event OrderEvent) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case s.events <- event:
+	default:
+		log.Printf("FIX 会话 %s 事件队列已满，断开连接", s.clientCompID)
+		s.conn.Close()
+	}
+}
+
+// eventLoop 按顺序处理会话的订单事件，会话结束后退出
+func (s *fixSession) eventLoop() {
+	for {
+		select {
+		case event := <-s.events:
+			s.handleOrderEvent(event)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handleOrderEvent 把订单事件转换为执行报告后投递到发送队列。
+// 不在跟踪中的订单和改单被拒时的原订单需要查询数据库，查询期间不持有 ordersMu
+func (s *fixSession) handleOrderEvent(event OrderEvent) {
+	s.ordersMu.Lock()
+	state := s.orders[event.OrderID]
+	s.ordersMu.Unlock()
+
+	if state == nil {
+		// 事件经 outbox 在成交写入数据库后发布，加载的成交已包含本事件的成交
+		model, err := s.gw.pc.GetOrder(event.OrderID)
+		if err == nil {
+			_, err = s.loadState(model)
+		}
+		if err != nil {
+			log.Printf("查询订单失败: %v", err)
+			return
+		}
+	}
+
+	report := s.executionReport(event)
+	if report == nil {
+		return
+	}
+	if err := s.send(report); err != nil {
+		log.Printf("发送 FIX 执行报告失败: %v", err)
+	}
+}
+
+// executionReport 更新订单跟踪信息并生成执行报告，不需要回报时返回 nil
+func (s *fixSession) executionReport(event OrderEvent) *fixMessage {
+	s.ordersMu.Lock()
+	defer s.ordersMu.Unlock()
+	state := s.orders[event.OrderID]
+	if state == nil {
+		return nil
+	}
+
+	report := newFIXMessage(fixMsgExecutionReport).
+		Set(tagOrderID, event.OrderID).
+		Set(tagExecID, uuid.New().String())
+	if state.ClOrdID != "" {
+		report.Set(tagClOrdID, state.ClOrdID)
+	}
+	leaves := event.RemainingAmount
+	terminal := false
+	switch event.Event {
+	case EventAccepted:
+		if state.OrigClOrdID != "" {
+			report.Set(tagExecType, "5").Set(tagOrigClOrdID, state.OrigClOrdID)
+			delete(s.orders, state.OrigOrderID)
+		} else {
+			report.Set(tagExecType, "0")
+		}
+		report.Set(tagOrdStatus, "0")
+	case EventPartiallyFilled, EventFilled:
+		if event.Fill == nil {
+			return nil
+		}
+		if _, ok := state.Counted[event.Fill.TradeID]; !ok {
+			state.CumQty = state.CumQty.Add(event.Fill.Amount)
+			state.CumNotional = state.CumNotional.Add(event.Fill.Price.Mul(event.Fill.Amount))
+		}
+		report.Set(tagExecType, "F").
+			Set(tagExecID, event.Fill.TradeID+"-"+event.Fill.Liquidity).
+			Set(tagLastPx, event.Fill.Price.String()).
+			Set(tagLastQty, event.Fill.Amount.String())
+		if event.Event == EventFilled {
+			report.Set(tagOrdStatus, "2")
+			terminal = true
+		} else {
+			report.Set(tagOrdStatus, "1")
+		}
+	case EventCanceled:
+		if state.Replacing {
+			return nil // 由新订单的 Replaced 回报体现
+		}
+		report.Set(tagExecType, "4").Set(tagOrdStatus, "4")
+		if state.CancelClOrdID != "" {
+			report.Set(tagClOrdID, state.CancelClOrdID).Set(tagOrigClOrdID, state.ClOrdID)
+		}
+		leaves, terminal = decimal.Zero, true
+	case EventExpired:
+		report.Set(tagExecType, "C").Set(tagOrdStatus, "C")
+		leaves, terminal = decimal.Zero, true
+	case EventRejected:
+		delete(s.orders, event.OrderID)
+		if state.OrigOrderID != "" {
+			return s.rejectReplace(state, event.Reason)
+		}
+		report.Set(tagExecType, "8").Set(tagOrdStatus, "8").Set(tagText, event.Reason)
+		leaves = decimal.Zero
+	default:
+		return nil
+	}
+	state.OrdStatus = report.Get(tagOrdStatus)
+	state.Done = terminal
+	// 正在被改单替换的订单保留到改单结果回报，改单被拒时以其状态回报
+	if terminal && !state.Replacing {
+		delete(s.orders, event.OrderID)
+	}
+
+	avgPx := decimal.Zero
+	if state.CumQty.IsPositive() {
+		avgPx = state.CumNotional.Div(state.CumQty)
+	}
+	report.Set(tagSymbol, state.Symbol).
+		Set(tagSide, state.Side).
+		Set(tagOrderQty, state.OrderQty.String()).
+		Set(tagLeavesQty, leaves.String()).
+		Set(tagCumQty, state.CumQty.String()).
+		Set(tagAvgPx, avgPx.String()).
+		Set(tagTransactTime, time.Unix(event.Timestamp, 0).UTC().Format(fixTimeLayout))
+	if event.OrderKind == "LIMIT" {
+		report.Set(tagOrdType, "2").Set(tagPrice, event.Price.String())
+	} else {
+		report.Set(tagOrdType, "1")
+	}
+	return report
+}
+
+// rejectReplace 改单时原订单无法撤销，新订单被拒绝，返回 OrderCancelReject，OrdStatus 为原订单的状态。
+// 调用方需持有 ordersMu
+func (s *fixSession) rejectReplace(state *fixOrderState, reason string) *fixMessage {
+	status := "8"
+	if orig := s.orders[state.OrigOrderID]; orig != nil {
+		if orig.OrdStatus != "" {
+			status = orig.OrdStatus
+		}
+		orig.Replacing = false
+		if orig.Done {
+			delete(s.orders, orig.OrderID)
+		}
+	}
+	return newFIXMessage(fixMsgOrderCancelReject).
+		Set(tagOrderID, state.OrigOrderID).
+		Set(tagClOrdID, state.ClOrdID).
+		Set(tagOrigClOrdID, state.OrigClOrdID).
+		Set(tagOrdStatus, status).
+		Set(tagCxlRejResponseTo, "2").
+		Set(tagCxlRejReason, "0").
+		Set(tagText, reason)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestFIXExecutionReportLoadedOrder(t *testing.T) {
+	// 从数据库加载的订单已有两笔成交，其中 t2 的事件尚未回报
+	clOrdID := "c1"
+	model := &OrderModel{OrderID: "o1", ClientOrderID: &clOrdID, Pair: "BTC_USDT", OrderType: "BID", OrderKind: "LIMIT", Price: 10, Amount: 5, Status: "PARTIALLY_FILLED"}
+	trades := []TradeModel{
+		{TradeID: "t1", BidOrderID: "o1", Price: 10, Amount: 1},
+		{TradeID: "t2", BidOrderID: "o1", Price: 8, Amount: 1},
+	}
+	s := &fixSession{orders: map[string]*fixOrderState{"o1": newFIXOrderState(model, trades)}}
+
+	fill := func(tradeID string, price int64, remaining int64) *fixMessage {
+		return s.executionReport(OrderEvent{
+			Event:           EventPartiallyFilled,
+			OrderID:         "o1",
+			OrderKind:       "LIMIT",
+			Price:           decimal.NewFromInt(10),
+			RemainingAmount: decimal.NewFromInt(remaining),
+			Fill:            &Fill{TradeID: tradeID, Price: decimal.NewFromInt(price), Amount: decimal.NewFromInt(1), Liquidity: "MAKER"},
+		})
+	}
+	tests := []struct {
+		tradeID       string
+		price         int64
+		remaining     int64
+		cumQty, avgPx string
+	}{
+		{"t2", 8, 3, "2", "9"}, // 已计入的成交不再累加
+		{"t3", 12, 2, "3", "10"},
+	}
+	for _, tt := range tests {
+		report := fill(tt.tradeID, tt.price, tt.remaining)
+		if report == nil {
+			t.Fatalf("成交 %s 没有生成执行报告", tt.tradeID)
+		}
+		if got := report.Get(tagCumQty); got != tt.cumQty {
+			t.Errorf("成交 %s 后 CumQty = %s, 期望 %s", tt.tradeID, got, tt.cumQty)
+		}
+		if got := report.Get(tagAvgPx); got != tt.avgPx {
+			t.Errorf("成交 %s 后 AvgPx = %s, 期望 %s", tt.tradeID, got, tt.avgPx)
+		}
+		if got := report.Get(tagClOrdID); got != clOrdID {
+			t.Errorf("ClOrdID = %s, 期望 %s", got, clOrdID)
+		}
+	}
+}
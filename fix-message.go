@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	fixBeginString    = "FIX.4.4"
+	fixSOH            = '\x01'
+	fixTimeLayout     = "20060102-15:04:05.000" // UTCTimestamp
+	fixMaxMessageSize = 64 * 1024
+)
+
+// FIX 字段标签
+const (
+	tagAvgPx               = 6
+	tagBeginSeqNo          = 7
+	tagBeginString         = 8
+	tagBodyLength          = 9
+	tagCheckSum            = 10
+	tagClOrdID             = 11
+	tagCumQty              = 14
+	tagEndSeqNo            = 16
+	tagExecID              = 17
+	tagLastPx              = 31
+	tagLastQty             = 32
+	tagMsgSeqNum           = 34
+	tagMsgType             = 35
+	tagNewSeqNo            = 36
+	tagOrderID             = 37
+	tagOrderQty            = 38
+	tagOrdStatus           = 39
+	tagOrdType             = 40
+	tagOrigClOrdID         = 41
+	tagPossDupFlag         = 43
+	tagPrice               = 44
+	tagRefSeqNum           = 45
+	tagSenderCompID        = 49
+	tagSendingTime         = 52
+	tagSide                = 54
+	tagSymbol              = 55
+	tagTargetCompID        = 56
+	tagText                = 58
+	tagTransactTime        = 60
+	tagEncryptMethod       = 98
+	tagCxlRejReason        = 102
+	tagOrdRejReason        = 103
+	tagHeartBtInt          = 108
+	tagTestReqID           = 112
+	tagGapFillFlag         = 123
+	tagResetSeqNumFlag     = 141
+	tagExecType            = 150
+	tagLeavesQty           = 151
+	tagRefMsgType          = 372
+	tagSessionRejectReason = 373
+	tagCxlRejResponseTo    = 434
+	tagUsername            = 553
+	tagPassword            = 554
+)
+
+// FIX 消息类型
+const (
+	fixMsgHeartbeat                 = "0"
+	fixMsgTestRequest               = "1"
+	fixMsgResendRequest             = "2"
+	fixMsgReject                    = "3"
+	fixMsgSequenceReset             = "4"
+	fixMsgLogout                    = "5"
+	fixMsgExecutionReport           = "8"
+	fixMsgOrderCancelReject         = "9"
+	fixMsgLogon                     = "A"
+	fixMsgNewOrderSingle            = "D"
+	fixMsgOrderCancelRequest        = "F"
+	fixMsgOrderCancelReplaceRequest = "G"
+)
+
+type fixField struct {
+	Tag   int
+	Value string
+}
+
+// fixMessage 按顺序保存的 FIX 字段，不含 BeginString、BodyLength 和 CheckSum
+type fixMessage struct {
+	fields []fixField
+}
+
+func newFIXMessage(msgType string) *fixMessage {
+	m := &fixMessage{}
+	m.Set(tagMsgType, msgType)
+	return m
+}
+
+// Get 返回字段值，不存在时返回空字符串
+func (m *fixMessage) Get(tag int) string {
+	for _, f := range m.fields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Set 设置字段值，已存在时覆盖
+func (m *fixMessage) Set(tag int, value string) *fixMessage {
+	for i, f := range m.fields {
+		if f.Tag == tag {
+			m.fields[i].Value = value
+			return m
+		}
+	}
+	m.fields = append(m.fields, fixField{Tag: tag, Value: value})
+	return m
+}
+
+// MsgType 消息类型
+func (m *fixMessage) MsgType() string {
+	return m.Get(tagMsgType)
+}
+
+// SeqNum 消息序号，缺失或无效时返回 0
+func (m *fixMessage) SeqNum() int {
+	seq, _ := strconv.Atoi(m.Get(tagMsgSeqNum))
+	return seq
+}
+
+// String 以 | 分隔字段，用于日志
+func (m *fixMessage) String() string {
+	return strings.ReplaceAll(string(m.encode()), string(fixSOH), "|")
+}
+
+// fixHeaderTags 标准头中 BodyLength 之后的字段，按此顺序写在正文最前面
+var fixHeaderTags = []int{tagMsgType, tagSenderCompID, tagTargetCompID, tagMsgSeqNum, tagPossDupFlag, tagSendingTime}
+
+func isFIXHeaderTag(tag int) bool {
+	for _, t := range fixHeaderTags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// encode 编码为完整消息，计算 BodyLength 和 CheckSum
+func (m *fixMessage) encode() []byte {
+	var body bytes.Buffer
+	for _, tag := range fixHeaderTags {
+		if value := m.Get(tag); value != "" {
+			fmt.Fprintf(&body, "%d=%s%c", tag, value, fixSOH)
+		}
+	}
+	for _, f := range m.fields {
+		if f.Tag == tagBeginString || f.Tag == tagBodyLength || f.Tag == tagCheckSum || isFIXHeaderTag(f.Tag) {
+			continue
+		}
+		fmt.Fprintf(&body, "%d=%s%c", f.Tag, f.Value, fixSOH)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d=%s%c%d=%d%c", tagBeginString, fixBeginString, fixSOH, tagBodyLength, body.Len(), fixSOH)
+	buf.Write(body.Bytes())
+	fmt.Fprintf(&buf, "%d=%03d%c", tagCheckSum, fixChecksum(buf.Bytes()), fixSOH)
+	return buf.Bytes()
+}
+
+func fixChecksum(data []byte) int {
+	sum := 0
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// readFIXMessage 从连接读取一条消息，校验 BeginString、BodyLength 和 CheckSum
+func readFIXMessage(r *bufio.Reader) (*fixMessage, error) {
+	begin, err := r.ReadString(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	if begin != fmt.Sprintf("%d=%s%c", tagBeginString, fixBeginString, fixSOH) {
+		return nil, fmt.Errorf("无效的 BeginString: %q", begin)
+	}
+	lengthField, err := r.ReadString(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(lengthField, "9="), string(fixSOH)))
+	if !strings.HasPrefix(lengthField, "9=") || err != nil || length <= 0 || length > fixMaxMessageSize {
+		return nil, fmt.Errorf("无效的 BodyLength: %q", lengthField)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := r.ReadString(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(trailer, "10="), string(fixSOH)))
+	if !strings.HasPrefix(trailer, "10=") || err != nil {
+		return nil, fmt.Errorf("无效的 CheckSum: %q", trailer)
+	}
+	if expected := fixChecksum([]byte(begin + lengthField + string(body))); checksum != expected {
+		return nil, fmt.Errorf("CheckSum 错误: %d, 期望 %d", checksum, expected)
+	}
+
+	m := &fixMessage{}
+	for _, field := range strings.Split(strings.TrimSuffix(string(body), string(fixSOH)), string(fixSOH)) {
+		tagStr, value, ok := strings.Cut(field, "=")
+		tag, err := strconv.Atoi(tagStr)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("无效的字段: %q", field)
+		}
+		m.fields = append(m.fields, fixField{Tag: tag, Value: value})
+	}
+	if m.MsgType() == "" {
+		return nil, errors.New("缺少 MsgType")
+	}
+	return m, nil
+}
@@ -0,0 +1,564 @@
+package main
+
+//go:generate protoc -I proto --go_out=. --go_opt=module=orderbook --go-grpc_out=. --go-grpc_opt=module=orderbook orderbook.proto
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "orderbook/proto/orderbookpb"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultGRPCAddr   = ":9090"
+	grpcSendQueueSize = 256 // 每个推送流的发送队列长度
+)
+
+// gRPC 认证元数据，签名为 hex(HMAC-SHA256(secret, timestamp + "GRPC" + full_method + request))，
+// request 为一元调用请求消息的确定性 protobuf 序列化结果，流式调用为空
+const (
+	mdAPIKey     = "x-api-key"
+	mdTimestamp  = "x-timestamp"
+	mdRecvWindow = "x-recv-window"
+	mdSignature  = "x-signature"
+)
+
+// grpcMethodAuth 需要认证的方法及其权限和限流类别，未列出的方法无需认证。
+// OrderEntry 流内的每条请求单独限流
+var grpcMethodAuth = map[string]struct{ scope, limit string }{
+	pb.OrderBookService_PlaceOrder_FullMethodName:  {ScopeTrade, LimitOrders},
+	pb.OrderBookService_CancelOrder_FullMethodName: {ScopeTrade, LimitCancels},
+	pb.OrderBookService_GetOrder_FullMethodName:    {ScopeRead, LimitQueries},
+	pb.OrderBookService_OrderEntry_FullMethodName:  {ScopeTrade, ""},
+}
+
+var (
+	protoSides = map[pb.Side]string{pb.Side_SIDE_BID: "BID", pb.Side_SIDE_ASK: "ASK"}
+	protoKinds = map[pb.OrderKind]string{pb.OrderKind_ORDER_KIND_LIMIT: "LIMIT", pb.OrderKind_ORDER_KIND_MARKET: "MARKET"}
+)
+
+// getGRPCAddr 返回 gRPC 服务监听地址，可通过环境变量 GRPC_ADDR 配置
+func getGRPCAddr() string {
+	if addr := os.Getenv("GRPC_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultGRPCAddr
+}
+
+// grpcCredentials 读取环境变量 GRPC_TLS_CERT 和 GRPC_TLS_KEY 指定的证书，未配置时返回 nil，
+// 此时服务必须部署在终结 TLS 的代理之后，不能直接暴露
+func grpcCredentials() (credentials.TransportCredentials, error) {
+	cert, key := os.Getenv("GRPC_TLS_CERT"), os.Getenv("GRPC_TLS_KEY")
+	if cert == "" && key == "" {
+		return nil, nil
+	}
+	return credentials.NewServerTLSFromFile(cert, key)
+}
+
+// grpcServer 实现 OrderBookService，下单和撤单与 HTTP 接口共用校验规则和撮合指令通道
+type grpcServer struct {
+	pb.UnimplementedOrderBookServiceServer
+	pc      *PostgresClient
+	rc      *RedisClient
+	limiter *rateLimiter
+}
+
+func newGRPCServer(pc *PostgresClient, rc *RedisClient, limiter *rateLimiter) (*grpc.Server, error) {
+	s := &grpcServer{pc: pc, rc: rc, limiter: limiter}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.unaryAuth), grpc.StreamInterceptor(s.streamAuth)}
+	creds, err := grpcCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	} else {
+		log.Printf("gRPC 服务未配置 TLS 证书，必须部署在终结 TLS 的代理之后")
+	}
+	server := grpc.NewServer(opts...)
+	pb.RegisterOrderBookServiceServer(server, s)
+	return server, nil
+}
+
+// grpcPrincipal 从上下文中取出调用方，未认证时返回 nil
+func grpcPrincipal(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// authorize 校验元数据中的签名和方法权限，并按限流类别扣减令牌。
+// 一元调用的签名包含请求消息，换用其他请求内容时签名不再有效；流式调用建立时还没有消息，
+// req 为 nil，签名只包含方法，同一签名只能建立一次流，时间窗口内也不能重放
+func (s *grpcServer) authorize(ctx context.Context, fullMethod string, req proto.Message) (context.Context, error) {
+	auth, ok := grpcMethodAuth[fullMethod]
+	if !ok {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = (proto.MarshalOptions{Deterministic: true}).Marshal(req); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "无法序列化请求")
+		}
+	}
+	timestamp, signature := get(mdTimestamp), get(mdSignature)
+	principal, err := verifyAPIKey(s.pc, get(mdAPIKey), timestamp, get(mdRecvWindow), signature, func(secret string) string {
+		return signRequest(secret, timestamp, "GRPC", fullMethod, body)
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if req == nil {
+		fresh, err := s.rc.ClaimSignature(signature, (maxRecvWindow+1000)*time.Millisecond)
+		if err != nil {
+			log.Printf("记录 gRPC 签名失败: %v", err)
+			return nil, status.Error(codes.Internal, "校验签名失败")
+		}
+		if !fresh {
+			return nil, status.Error(codes.Unauthenticated, "签名已使用")
+		}
+	}
+	if !principal.HasScope(auth.scope) {
+		return nil, status.Error(codes.PermissionDenied, "API Key 没有 "+auth.scope+" 权限")
+	}
+	ctx = context.WithValue(ctx, principalContextKey{}, principal)
+	if auth.limit != "" {
+		if err := s.allow(ctx, auth.limit); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// allow 按调用方的 API Key、用户和来源 IP 扣减 1 个令牌，与 HTTP 接口共用额度
+func (s *grpcServer) allow(ctx context.Context, class string) error {
+	ids := make(map[string]string)
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			ids["ip"] = host
+		}
+	}
+	if principal := grpcPrincipal(ctx); principal != nil {
+		ids["key"] = principal.APIKey
+		ids["user"] = strconv.Itoa(principal.UserID)
+	}
+	if ok, _, _, retryAfter := s.limiter.take(class, ids, 1); !ok {
+		return status.Errorf(codes.ResourceExhausted, "请求过于频繁，请在 %v 后重试", retryAfter.Round(time.Millisecond))
+	}
+	return nil
+}
+
+func (s *grpcServer) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "无效的请求")
+	}
+	ctx, err := s.authorize(ctx, info.FullMethod, msg)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *grpcServer) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(ss.Context(), info.FullMethod, nil)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authedStream 携带调用方的流
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
+
+// orderStatusError 把下单、撤单和查询的错误转换为 gRPC 状态
+func orderStatusError(err error) error {
+	switch {
+	case errors.Is(err, errDuplicateOrderID):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, errOrderNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errOrderClosed), errorCode(err) == ErrCodeRiskRejected, errorCode(err) == ErrCodeMarketNotTrading:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errSaveOrder), errors.Is(err, errSubmitOrder), errors.Is(err, errQueryOrder), errors.Is(err, errSubmitCancel),
+		errors.Is(err, errRiskCheck):
+		return status.Error(codes.Internal, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+// PlaceOrder 下单
+func (s *grpcServer) PlaceOrder(ctx context.Context, req *pb.PlaceOrderRequest) (*pb.PlaceOrderResponse, error) {
+	order := Order{
+		OrderID:       req.OrderId,
+		ClientOrderID: req.ClientOrderId,
+		OrderType:     protoSides[req.Side],
+		OrderKind:     protoKinds[req.Kind],
+	}
+	var err error
+	if req.Price != "" {
+		if order.Price, err = decimal.NewFromString(req.Price); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "无效的价格")
+		}
+	}
+	if req.Amount != "" || req.QuoteAmount == "" {
+		if order.Amount, err = decimal.NewFromString(req.Amount); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "无效的数量")
+		}
+	}
+	if order.QuoteAmount, err = optionalDecimal(req.QuoteAmount); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "无效的计价币种金额")
+	}
+	if order.ProtectionPrice, err = optionalDecimal(req.ProtectionPrice); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "无效的保护价")
+	}
+	if order.MaxSlippage, err = optionalDecimal(req.MaxSlippage); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "无效的最大滑点")
+	}
+	principal := grpcPrincipal(ctx)
+	order.UserID = principal.UserID
+	order.APIKey = principal.APIKey
+
+	existing, err := placeOrder(s.pc, s.rc, defaultPair, &order)
+	if existing != nil {
+		return &pb.PlaceOrderResponse{
+			OrderId:       existing.OrderID,
+			ClientOrderId: order.ClientOrderID,
+			Duplicate:     true,
+			Status:        existing.Status,
+		}, nil
+	}
+	if err != nil {
+		return nil, orderStatusError(err)
+	}
+	return &pb.PlaceOrderResponse{OrderId: order.OrderID, ClientOrderId: order.ClientOrderID}, nil
+}
+
+// optionalDecimal 解析可选的十进制字段，空字符串表示未指定
+func optionalDecimal(s string) (*decimal.Decimal, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// CancelOrder 撤单，撤单结果通过订单事件推送
+func (s *grpcServer) CancelOrder(ctx context.Context, req *pb.CancelOrderRequest) (*pb.CancelOrderResponse, error) {
+	order, err := findUserOrder(s.pc, grpcPrincipal(ctx).UserID, req.GetOrderId(), req.GetClientOrderId())
+	if err != nil {
+		return nil, orderStatusError(err)
+	}
+	if err := cancelUserOrder(s.rc, order); err != nil {
+		return nil, orderStatusError(err)
+	}
+	return &pb.CancelOrderResponse{OrderId: order.OrderID}, nil
+}
+
+// GetOrder 查询订单
+func (s *grpcServer) GetOrder(ctx context.Context, req *pb.GetOrderRequest) (*pb.Order, error) {
+	order, err := findUserOrder(s.pc, grpcPrincipal(ctx).UserID, req.GetOrderId(), req.GetClientOrderId())
+	if err != nil {
+		return nil, orderStatusError(err)
+	}
+	info := order.Info()
+	return &pb.Order{
+		OrderId:       info.OrderID,
+		ClientOrderId: info.ClientOrderID,
+		UserId:        int64(info.UserID),
+		Pair:          info.Pair,
+		Side:          sideToProto(info.OrderType),
+		Kind:          kindToProto(info.OrderKind),
+		Price:         info.Price.String(),
+		Amount:        info.Amount.String(),
+		Status:        info.Status,
+		Timestamp:     info.Timestamp,
+	}, nil
+}
+
+// StreamTrades 推送交易对的成交
+func (s *grpcServer) StreamTrades(req *pb.StreamTradesRequest, stream pb.OrderBookService_StreamTradesServer) error {
+	if _, ok := getMarket(req.Pair); !ok {
+		return status.Error(codes.InvalidArgument, "不支持的交易对")
+	}
+	sub := subscribeGRPC("trades:" + req.Pair)
+	defer unsubscribeGRPC(sub)
+	return forwardGRPC(stream, sub)
+}
+
+// StreamDepth 推送盘口快照和后续增量。
+// 持有盘口状态锁期间完成订阅并取快照，保证快照与后续增量之间不会遗漏或重复
+func (s *grpcServer) StreamDepth(req *pb.StreamDepthRequest, stream pb.OrderBookService_StreamDepthServer) error {
+	if _, ok := getMarket(req.Pair); !ok {
+		return status.Error(codes.InvalidArgument, "不支持的交易对")
+	}
+	state := getDepthState(req.Pair)
+	state.mu.Lock()
+	sub := subscribeGRPC("depth:" + req.Pair)
+	snapshot := state.snapshot(req.Pair)
+	state.mu.Unlock()
+	defer unsubscribeGRPC(sub)
+
+	if err := stream.Send(&pb.DepthUpdate{
+		Pair:     snapshot.Pair,
+		UpdateId: snapshot.UpdateID,
+		Snapshot: true,
+		Bids:     levelsToProto(snapshot.Bids),
+		Asks:     levelsToProto(snapshot.Asks),
+	}); err != nil {
+		return err
+	}
+	return forwardGRPC(stream, sub)
+}
+
+// OrderEntry 双向下单流。请求按顺序处理，结果和该 API Key 下订单的事件由同一个循环发送
+func (s *grpcServer) OrderEntry(stream pb.OrderBookService_OrderEntryServer) error {
+	ctx := stream.Context()
+	sub := subscribeGRPC("orders:" + grpcPrincipal(ctx).APIKey)
+	defer unsubscribeGRPC(sub)
+
+	responses := make(chan *pb.OrderEntryResponse, grpcSendQueueSize)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case responses <- s.handleOrderEntry(ctx, req):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-recvErr:
+			// 客户端结束发送后，发出已处理请求的结果再关闭
+			for len(responses) > 0 {
+				if err := stream.Send(<-responses); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case resp := <-responses:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case msg, ok := <-sub.send:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "slow consumer")
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleOrderEntry 处理下单流中的一条请求
+func (s *grpcServer) handleOrderEntry(ctx context.Context, req *pb.OrderEntryRequest) *pb.OrderEntryResponse {
+	resp := &pb.OrderEntryResponse{RequestId: req.RequestId}
+	var err error
+	switch action := req.Action.(type) {
+	case *pb.OrderEntryRequest_Place:
+		if err = s.allow(ctx, LimitOrders); err == nil {
+			var placed *pb.PlaceOrderResponse
+			if placed, err = s.PlaceOrder(ctx, action.Place); err == nil {
+				resp.Result = &pb.OrderEntryResponse_Placed{Placed: placed}
+			}
+		}
+	case *pb.OrderEntryRequest_Cancel:
+		if err = s.allow(ctx, LimitCancels); err == nil {
+			var canceled *pb.CancelOrderResponse
+			if canceled, err = s.CancelOrder(ctx, action.Cancel); err == nil {
+				resp.Result = &pb.OrderEntryResponse_Canceled{Canceled: canceled}
+			}
+		}
+	default:
+		err = status.Error(codes.InvalidArgument, "需要 place 或 cancel")
+	}
+	if err != nil {
+		st := status.Convert(err)
+		resp.Result = &pb.OrderEntryResponse_Error{Error: &pb.Error{Code: st.Code().String(), Message: st.Message()}}
+	}
+	return resp
+}
+
+// grpcSubscriber 一个推送流的订阅，topic 为 trades:<pair>、depth:<pair> 或 orders:<api_key>
+type grpcSubscriber struct {
+	topic string
+	send  chan proto.Message // 发送队列写满时被关闭
+}
+
+var (
+	grpcSubs   = make(map[*grpcSubscriber]struct{})
+	grpcSubsMu sync.Mutex
+)
+
+func subscribeGRPC(topic string) *grpcSubscriber {
+	sub := &grpcSubscriber{topic: topic, send: make(chan proto.Message, grpcSendQueueSize)}
+	grpcSubsMu.Lock()
+	grpcSubs[sub] = struct{}{}
+	grpcSubsMu.Unlock()
+	return sub
+}
+
+func unsubscribeGRPC(sub *grpcSubscriber) {
+	grpcSubsMu.Lock()
+	delete(grpcSubs, sub)
+	grpcSubsMu.Unlock()
+}
+
+// publishGRPC 投递消息到订阅了该主题的流，队列已满的流视为慢连接并断开
+func publishGRPC(topic string, msg proto.Message) {
+	grpcSubsMu.Lock()
+	defer grpcSubsMu.Unlock()
+	for sub := range grpcSubs {
+		if sub.topic != topic {
+			continue
+		}
+		select {
+		case sub.send <- msg:
+		default:
+			log.Printf("gRPC 订阅 %s 发送队列已满，断开连接", topic)
+			delete(grpcSubs, sub)
+			close(sub.send)
+		}
+	}
+}
+
+// forwardGRPC 把订阅收到的消息发送到流，直到客户端断开
+func forwardGRPC(stream grpc.ServerStream, sub *grpcSubscriber) error {
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg, ok := <-sub.send:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "slow consumer")
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publishGRPCTrade 推送成交到 StreamTrades
+func publishGRPCTrade(trade Trade) {
+	publishGRPC("trades:"+trade.Pair, &pb.Trade{
+		TradeId:    trade.TradeID,
+		Pair:       trade.Pair,
+		BidOrderId: trade.BidOrderID,
+		AskOrderId: trade.AskOrderID,
+		Price:      trade.Price.String(),
+		Amount:     trade.Amount.String(),
+		Timestamp:  trade.Timestamp,
+	})
+}
+
+// publishGRPCDepth 推送盘口增量到 StreamDepth，调用方需持有该交易对盘口状态的锁
+func publishGRPCDepth(update OrderBookUpdate) {
+	publishGRPC("depth:"+update.Pair, &pb.DepthUpdate{
+		Pair:     update.Pair,
+		UpdateId: update.UpdateID,
+		Bids:     levelsToProto(update.Bids),
+		Asks:     levelsToProto(update.Asks),
+	})
+}
+
+// publishGRPCOrderEvent 推送订单事件到同一 API Key 的 OrderEntry 流
+func publishGRPCOrderEvent(event OrderEvent) {
+	if event.APIKey == "" {
+		return
+	}
+	pbEvent := &pb.OrderEvent{
+		Event:           event.Event,
+		OrderId:         event.OrderID,
+		Pair:            event.Pair,
+		Side:            sideToProto(event.OrderType),
+		Kind:            kindToProto(event.OrderKind),
+		Price:           event.Price.String(),
+		RemainingAmount: event.RemainingAmount.String(),
+		Reason:          event.Reason,
+		Timestamp:       event.Timestamp,
+	}
+	if event.Fill != nil {
+		pbEvent.Fill = &pb.Fill{
+			TradeId:   event.Fill.TradeID,
+			Price:     event.Fill.Price.String(),
+			Amount:    event.Fill.Amount.String(),
+			Liquidity: event.Fill.Liquidity,
+		}
+	}
+	publishGRPC("orders:"+event.APIKey, &pb.OrderEntryResponse{Result: &pb.OrderEntryResponse_Event{Event: pbEvent}})
+}
+
+func levelsToProto(levels []OrderBookLevel) []*pb.DepthLevel {
+	result := make([]*pb.DepthLevel, len(levels))
+	for i, level := range levels {
+		result[i] = &pb.DepthLevel{Price: level.Price.String(), Amount: level.Amount.String()}
+	}
+	return result
+}
+
+func sideToProto(orderType string) pb.Side {
+	for side, s := range protoSides {
+		if s == orderType {
+			return side
+		}
+	}
+	return pb.Side_SIDE_UNSPECIFIED
+}
+
+func kindToProto(orderKind string) pb.OrderKind {
+	for kind, k := range protoKinds {
+		if k == orderKind {
+			return kind
+		}
+	}
+	return pb.OrderKind_ORDER_KIND_UNSPECIFIED
+}
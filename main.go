@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -11,9 +13,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
 )
 
 func main() {
+	if err := validateMarkets(); err != nil {
+		log.Fatal("交易对配置无效:", err)
+	}
+
 	// 初始化 Redis
 	rc := NewRedisClient()
 	defer rc.Close()
@@ -25,69 +32,142 @@ func main() {
 	}
 	defer pc.Close()
 
-	// 初始化订单簿
-	if err := rc.InitOrderBook("BTC_USDT"); err != nil {
-		log.Fatal("初始化订单簿失败:", err)
+	// 订单簿保留在 Redis 中，旧结构的订单簿先迁移
+	if err := rc.MigrateOrderBook(defaultPair); err != nil {
+		log.Fatal("迁移订单簿失败:", err)
 	}
 
+	// 恢复交易对状态，之后只由撮合引擎修改
+	if err := loadMarketStates(rc); err != nil {
+		log.Fatal("加载交易对状态失败:", err)
+	}
+
+	// 初始化聚合盘口，之后随订单簿变化增量维护，按固定频率推送
+	seedDepth(getOrderBookSnapshot(rc, defaultPair))
+	rc.OnBookChange(applyBookChange)
+	go runDepthPublisher(getDepthPublishInterval())
+
+	// 持久化：先补写上次退出时未写入数据库的日志，再异步批量写入撮合结果
+	if err := replayJournal(rc, pc); err != nil {
+		log.Fatal("补写持久化日志失败:", err)
+	}
+	go runPersister(rc, pc)
+
+	// outbox 转发：成交、订单事件和余额变化写入数据库后发布到 Redis
+	go runOutboxRelay(rc, pc)
+
 	// Redis 订阅
 	go func() {
 		log.Println("启动 incoming_orders 订阅")
-		rc.SubscribeOrders("incoming_orders", func(order Order) {
-			log.Printf("处理订单: %+v", order)
-			if err := pc.SaveOrder(order); err != nil {
-				log.Printf("保存订单到数据库失败: %v", err)
-				return
-			}
-			var err error
-			if order.OrderKind == "MARKET" {
-				err = matchOrdersMarket(rc, pc, "BTC_USDT", order)
-			} else {
-				err = matchOrdersPriceLimit(rc, pc, "BTC_USDT", order)
-			}
-			if err != nil {
-				log.Printf("撮合订单失败: %v", err)
-			}
+		rc.SubscribeCommands("incoming_orders", func(cmd EngineCommand) {
+			processCommand(rc, pc, defaultPair, cmd)
+		})
+	}()
+
+	// 倒计时撤单检查
+	go runCountdownCancel(rc)
 
-			go func() {
-				// 撮合后推送盘口
-				snapshot := getOrderBookSnapshot(rc, "BTC_USDT")
-				broadcastOrderBook(snapshot)
-			}()
+	// 熔断到期恢复交易
+	go runMarketStateResume(rc)
+	go runAuctionIndicativePublisher(rc)
+	go func() {
+		log.Println("启动 market_state_updates 订阅")
+		rc.SubscribeMarketStates("market_state_updates", broadcastMarketState)
+	}()
+	go func() {
+		log.Println("启动 auction_updates 订阅")
+		rc.SubscribeAuctionIndicative("auction_updates", broadcastAuctionIndicative)
+	}()
+
+	// Redis 成交订阅，推送成交、行情和 K 线
+	go func() {
+		log.Println("启动 completed_trades 订阅")
+		rc.SubscribeTrades("completed_trades", func(trade Trade) {
+			broadcastTrade(trade)
+			publishGRPCTrade(trade)
+		})
+	}()
+
+	// FIX 网关
+	fixGW := newFIXGateway(rc, pc)
+	go func() {
+		if err := fixGW.ListenAndServe(getFIXAddr()); err != nil {
+			log.Fatal("FIX 网关启动失败:", err)
+		}
+	}()
+
+	// 订单事件与余额变化订阅，推送到用户私有频道、FIX 会话和 gRPC 下单流
+	go func() {
+		log.Println("启动 order_events 订阅")
+		rc.SubscribeOrderEvents("order_events", func(event OrderEvent) {
+			broadcastOrderEvent(event)
+			fixGW.onOrderEvent(event)
+			publishGRPCOrderEvent(event)
 		})
 	}()
+	go func() {
+		log.Println("启动 balance_updates 订阅")
+		rc.SubscribeBalanceUpdates("balance_updates", broadcastBalanceUpdate)
+	}()
 
-	// Redis 成交订阅
-	// go func() {
-	// 	log.Println("启动 completed_trades 订阅")
-	// 	rc.client.Subscribe(rc.ctx, "completed_trades").Channel()
-	// 	pubsub := rc.client.Subscribe(rc.ctx, "completed_trades")
-	// 	for msg := range pubsub.Channel() {
-	// 		log.Printf("收到成交消息: %s", msg.Payload)
-	// 		var trade Trade
-	// 		if err := json.Unmarshal([]byte(msg.Payload), &trade); err != nil {
-	// 			log.Printf("解析成交失败: %v, 消息: %s", err, msg.Payload)
-	// 			continue
-	// 		}
-	// 		log.Printf("解析成交成功: %+v", trade)
-	// 		// 可添加处理逻辑，如通知前端、记录日志
-	// 	}
-	// }()
+	// HTTP 和 gRPC 接口共用限流额度
+	limiter := newRateLimiter(getRateLimits())
 
 	// 启动 HTTP 服务器
 	go func() {
+		spec, err := loadOpenAPI()
+		if err != nil {
+			log.Fatal("加载接口定义失败:", err)
+		}
 		router := mux.NewRouter()
-		router.HandleFunc("/orders", handleOrder(pc, rc)).Methods("POST")
+		router.Use(validateRequests(spec))
+		// 公开接口，无需认证
+		router.HandleFunc("/openapi.json", handleOpenAPI).Methods("GET")
+		router.HandleFunc("/markets", limiter.limit(LimitQueries, handleMarkets)).Methods("GET")
+		router.HandleFunc("/markets/{pair}/depth", limiter.limit(LimitQueries, handleDepth)).Methods("GET")
+		router.HandleFunc("/markets/{pair}/ticker", limiter.limit(LimitQueries, handleTicker)).Methods("GET")
+
+		api := router.NewRoute().Subrouter()
+		api.Use(authenticate(pc))
+		// 批量接口需注册在 /orders/{order_id} 之前
+		api.HandleFunc("/orders/batch", requireScope(ScopeTrade, handleBatchOrder(pc, rc, limiter))).Methods("POST")
+		api.HandleFunc("/orders/batch", requireScope(ScopeTrade, handleBatchCancel(pc, rc, limiter))).Methods("DELETE")
+		api.HandleFunc("/orders/all", requireScope(ScopeTrade, limiter.limit(LimitCancels, handleCancelAll(rc)))).Methods("DELETE")
+		api.HandleFunc("/countdown-cancel", requireScope(ScopeTrade, limiter.limit(LimitCancels, handleCountdownCancel(rc)))).Methods("POST")
+		api.HandleFunc("/orders", requireScope(ScopeTrade, limiter.limit(LimitOrders, handleOrder(pc, rc)))).Methods("POST")
+		api.HandleFunc("/orders", requireScope(ScopeRead, limiter.limit(LimitQueries, handleGetOrder(pc)))).Methods("GET")
+		api.HandleFunc("/orders/{order_id}", requireScope(ScopeRead, limiter.limit(LimitQueries, handleGetOrder(pc)))).Methods("GET")
+		api.HandleFunc("/orders", requireScope(ScopeTrade, limiter.limit(LimitCancels, handleCancelOrder(pc, rc)))).Methods("DELETE")
+		api.HandleFunc("/orders/{order_id}", requireScope(ScopeTrade, limiter.limit(LimitCancels, handleCancelOrder(pc, rc)))).Methods("DELETE")
+		api.HandleFunc("/admin/markets/{pair}/state", requireScope(ScopeAdmin, handleSetMarketState(rc))).Methods("POST")
 		log.Println("HTTP 服务器启动在 :8080")
 		if err := http.ListenAndServe(":8080", router); err != nil {
 			log.Fatal("HTTP 服务器启动失败:", err)
 		}
 	}()
 
+	// 启动 gRPC 服务
 	go func() {
-		// 假设 rc 已初始化
-		http.HandleFunc("/ws/orderbook", wsOrderBookHandler(rc))
-		http.ListenAndServe(":8081", nil)
+		addr := getGRPCAddr()
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal("gRPC 服务启动失败:", err)
+		}
+		server, err := newGRPCServer(pc, rc, limiter)
+		if err != nil {
+			log.Fatal("gRPC 服务启动失败:", err)
+		}
+		log.Printf("gRPC 服务启动在 %s", addr)
+		if err := server.Serve(ln); err != nil {
+			log.Fatal("gRPC 服务启动失败:", err)
+		}
+	}()
+
+	go func() {
+		wsRouter := mux.NewRouter()
+		wsRouter.HandleFunc("/ws/orderbook", wsOrderBookHandler(rc))
+		wsRouter.Handle("/ws/private", authenticate(pc)(requireScope(ScopeRead, wsPrivateHandler(rc))))
+		http.ListenAndServe(":8081", wsRouter)
 	}()
 
 	go func() {
@@ -102,50 +182,312 @@ func handleOrder(pc *PostgresClient, rc *RedisClient) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var order Order
 		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-			http.Error(w, "无效的订单格式", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "无效的订单格式")
 			log.Printf("解析订单失败: %v", err)
 			return
 		}
+		// 下单用户取自 API Key，忽略请求体中的 user_id
+		principal := principalFrom(r)
+		order.UserID = principal.UserID
+		order.APIKey = principal.APIKey
 
-		// 验证订单字段
-		if order.OrderID == "" {
-			order.OrderID = uuid.New().String()
-		}
-		if order.OrderType != "BID" && order.OrderType != "ASK" {
-			http.Error(w, "无效的订单类型，必须是 BID 或 ASK", http.StatusBadRequest)
+		existing, err := placeOrder(pc, rc, defaultPair, &order)
+		if existing != nil {
+			// 重试请求，返回原订单的结果
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{
+				"message":         "订单已存在",
+				"order_id":        existing.OrderID,
+				"client_order_id": order.ClientOrderID,
+				"status":          existing.Status,
+			})
 			return
 		}
-		if order.OrderKind != "LIMIT" && order.OrderKind != "MARKET" {
-			http.Error(w, "无效的订单种类，必须是 LIMIT 或 MARKET", http.StatusBadRequest)
+		if err != nil {
+			writeAPIError(w, err)
 			return
 		}
-		if order.OrderKind == "LIMIT" && order.Price.LessThanOrEqual(decimal.Zero) {
-			http.Error(w, "限价订单价格必须大于 0", http.StatusBadRequest)
+
+		w.WriteHeader(http.StatusOK)
+		resp := map[string]string{"message": "订单提交成功", "order_id": order.OrderID}
+		if order.ClientOrderID != "" {
+			resp["client_order_id"] = order.ClientOrderID
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+var (
+	errDuplicateOrderID = newAPIError(http.StatusConflict, ErrCodeDuplicateOrderID, "订单 ID 已存在")
+	errSaveOrder        = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "保存订单失败")
+	errSubmitOrder      = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "提交订单失败")
+	errOrderNotFound    = newAPIError(http.StatusNotFound, ErrCodeOrderNotFound, "订单不存在")
+	errQueryOrder       = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "查询订单失败")
+	errOrderClosed      = newAPIError(http.StatusBadRequest, ErrCodeOrderClosed, "订单已结束，无法撤销")
+	errSubmitCancel     = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "提交撤单失败")
+	errDuplicateCancel  = newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "订单在本批次中重复")
+	errUnsupportedPair  = newAPIError(http.StatusBadRequest, ErrCodeUnsupportedPair, "不支持的交易对")
+)
+
+// placeOrder 校验、落库、风控检查后提交交易对 pair 的新订单到撮合引擎，HTTP 和 gRPC 接口共用。
+// client_order_id 重复时返回已存在的原订单，错误均为 *apiError
+func placeOrder(pc *PostgresClient, rc *RedisClient, pair string, order *Order) (*OrderModel, error) {
+	// 验证订单字段
+	if err := validateOrder(pair, order); err != nil {
+		return nil, err
+	}
+
+	// 先落库，订单 ID 或客户端订单 ID 重复时直接返回
+	existing, err := saveNewOrder(pc, *order)
+	if existing != nil || errors.Is(err, errDuplicateOrderID) {
+		return existing, err
+	}
+	if err != nil {
+		log.Printf("保存订单到数据库失败: %v", err)
+		return nil, errSaveOrder
+	}
+
+	// 交易对状态或风控拒绝的订单保留为 REJECTED，重试请求返回原订单
+	if err := checkOrderEntry(pc, rc, pair, *order); err != nil {
+		rejectOrders(pc, *order)
+		return nil, err
+	}
+
+	// 发布订单到 Redis
+	if err := rc.SubmitOrder(*order); err != nil {
+		log.Printf("提交订单到 Redis 失败: %v", err)
+		rejectOrders(pc, *order)
+		return nil, errSubmitOrder
+	}
+	return nil, nil
+}
+
+// validateOrder 按交易对 pair 的配置校验订单字段并补全默认值
+func validateOrder(pair string, order *Order) error {
+	market, ok := getMarket(pair)
+	if !ok {
+		return errUnsupportedPair
+	}
+	if order.OrderID == "" {
+		order.OrderID = uuid.New().String()
+	} else if _, err := uuid.Parse(order.OrderID); err != nil {
+		return invalidOrder("订单 ID 必须是 UUID")
+	}
+	if order.SessionID != "" {
+		if _, err := uuid.Parse(order.SessionID); err != nil {
+			return invalidOrder("会话 ID 必须是 UUID")
+		}
+	}
+	if len(order.ClientOrderID) > 64 {
+		return invalidOrder("客户端订单 ID 长度不能超过 64")
+	}
+	if order.OrderType != "BID" && order.OrderType != "ASK" {
+		return invalidOrder("无效的订单类型，必须是 BID 或 ASK")
+	}
+	if order.OrderKind != "LIMIT" && order.OrderKind != "MARKET" {
+		return invalidOrder("无效的订单种类，必须是 LIMIT 或 MARKET")
+	}
+	if order.OrderKind == "LIMIT" && order.Price.LessThanOrEqual(decimal.Zero) {
+		return invalidOrder("限价订单价格必须大于 0")
+	}
+	if order.OrderKind == "LIMIT" {
+		if _, err := encodePriceLevel(order.Price); err != nil {
+			return invalidOrder(err.Error())
+		}
+	}
+	if err := validateMarketOrder(order); err != nil {
+		return err
+	}
+	if order.OrderKind == "LIMIT" && market.TickSize.IsPositive() && !order.Price.Mod(market.TickSize).IsZero() {
+		return invalidOrder("订单价格必须是 " + market.TickSize.String() + " 的整数倍")
+	}
+	if order.QuoteAmount != nil {
+		// 按计价币种下单，成交数量由撮合引擎按预算计算
+		if !order.QuoteAmount.IsPositive() {
+			return invalidOrder("计价币种金额必须大于 0")
+		}
+		if !order.Amount.IsZero() {
+			return invalidOrder("amount 和 quote_amount 只能指定一个")
+		}
+	} else if order.Amount.LessThanOrEqual(decimal.Zero) {
+		return invalidOrder("订单数量必须大于 0")
+	} else if !floorToLot(order.Amount, market.LotSize).Equal(order.Amount) {
+		return invalidOrder("订单数量必须是 " + market.LotSize.String() + " 的整数倍")
+	}
+	if order.Timestamp == 0 {
+		order.Timestamp = time.Now().Unix()
+	}
+	return nil
+}
+
+// validateMarketOrder 校验只用于市价单的字段
+func validateMarketOrder(order *Order) error {
+	if order.OrderKind != "MARKET" {
+		if order.QuoteAmount != nil || order.ProtectionPrice != nil || order.MaxSlippage != nil {
+			return invalidOrder("quote_amount、protection_price 和 max_slippage 只能用于市价单")
+		}
+		return nil
+	}
+	if order.QuoteAmount != nil && order.OrderType != "BID" {
+		return invalidOrder("只有市价买单可以按计价币种下单")
+	}
+	if order.ProtectionPrice != nil && !order.ProtectionPrice.IsPositive() {
+		return invalidOrder("保护价必须大于 0")
+	}
+	if order.MaxSlippage != nil && (!order.MaxSlippage.IsPositive() || order.MaxSlippage.GreaterThanOrEqual(decimal.NewFromInt(1))) {
+		return invalidOrder("max_slippage 必须在 0 到 1 之间")
+	}
+	return nil
+}
+
+// saveNewOrder 保存新订单。client_order_id 重复时返回已存在的原订单，order_id 重复时返回 errDuplicateOrderID
+func saveNewOrder(pc *PostgresClient, order Order) (*OrderModel, error) {
+	err := pc.SaveOrder(order)
+	if err == nil || !errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, err
+	}
+	if order.ClientOrderID != "" {
+		if existing, err := pc.GetOrderByClientOrderID(order.UserID, order.ClientOrderID); err == nil {
+			return existing, nil
+		}
+	}
+	return nil, errDuplicateOrderID
+}
+
+// rejectOrders 已落库但未能提交到撮合引擎的订单标记为 REJECTED
+func rejectOrders(pc *PostgresClient, orders ...Order) {
+	for _, order := range orders {
+		if err := pc.UpdateOrderStatus(order.OrderID, "REJECTED"); err != nil {
+			log.Printf("更新订单状态失败: %v", err)
+		}
+	}
+}
+
+// findUserOrder 按 order_id 或 client_order_id 查找用户的订单
+func findUserOrder(pc *PostgresClient, userID int, orderID, clientOrderID string) (*OrderModel, error) {
+	var order *OrderModel
+	var err error
+	if orderID != "" {
+		if _, err := uuid.Parse(orderID); err != nil {
+			return nil, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "订单 ID 必须是 UUID")
+		}
+		order, err = pc.GetOrder(orderID)
+	} else if clientOrderID != "" {
+		order, err = pc.GetOrderByClientOrderID(userID, clientOrderID)
+	} else {
+		return nil, newAPIError(http.StatusBadRequest, ErrCodeInvalidRequest, "需要 order_id 或 client_order_id")
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && order.UserID != userID) {
+		return nil, errOrderNotFound
+	}
+	if err != nil {
+		log.Printf("查询订单失败: %v", err)
+		return nil, errQueryOrder
+	}
+	return order, nil
+}
+
+// lookupOrder 按路径中的 order_id 或查询参数 client_order_id 查找当前用户的订单
+func lookupOrder(pc *PostgresClient, r *http.Request) (*OrderModel, error) {
+	return findUserOrder(pc, principalFrom(r).UserID, mux.Vars(r)["order_id"], r.URL.Query().Get("client_order_id"))
+}
+
+// cancelUserOrder 提交撤单指令，撤单与下单经同一通道按顺序处理
+func cancelUserOrder(rc *RedisClient, order *OrderModel) error {
+	if !isOpenStatus(order.Status) {
+		return errOrderClosed
+	}
+	if err := checkCancelState(order.Pair); err != nil {
+		return err
+	}
+	if err := rc.SubmitCancel(order.OrderID, order.UserID); err != nil {
+		log.Printf("提交撤单到 Redis 失败: %v", err)
+		return errSubmitCancel
+	}
+	return nil
+}
+
+// handleGetOrder 处理 GET /orders/{order_id} 和 GET /orders?client_order_id= 请求
+func handleGetOrder(pc *PostgresClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		order, err := lookupOrder(pc, r)
+		if err != nil {
+			writeAPIError(w, err)
 			return
 		}
-		if order.Amount.LessThanOrEqual(decimal.Zero) {
-			http.Error(w, "订单数量必须大于 0", http.StatusBadRequest)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(order.Info())
+	}
+}
+
+// handleCancelOrder 处理 DELETE /orders/{order_id} 和 DELETE /orders?client_order_id= 请求
+func handleCancelOrder(pc *PostgresClient, rc *RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		order, err := lookupOrder(pc, r)
+		if err != nil {
+			writeAPIError(w, err)
 			return
 		}
-		if order.Timestamp == 0 {
-			order.Timestamp = time.Now().Unix()
+		if err := cancelUserOrder(rc, order); err != nil {
+			writeAPIError(w, err)
+			return
 		}
 
-		// 验证 user_id 存在
-		if err := pc.ValidateUser(order.UserID); err != nil {
-			http.Error(w, "用户不存在", http.StatusBadRequest)
-			log.Printf("用户验证失败: %v", err)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "撤单请求已提交", "order_id": order.OrderID})
+	}
+}
+
+// handleCancelAll 处理 DELETE /orders/all?pair=&side= 请求，撤销当前用户的全部挂单，
+// 等待撮合引擎处理完成后返回被撤销的订单 ID
+func handleCancelAll(rc *RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pair := r.URL.Query().Get("pair")
+		side := r.URL.Query().Get("side")
+		if _, ok := getMarket(pair); pair != "" && !ok {
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupportedPair, "不支持的交易对")
+			return
+		}
+		if side != "" && side != "BID" && side != "ASK" {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "无效的方向，必须是 BID 或 ASK")
 			return
 		}
+		// 与单笔撤单一样检查交易对状态，未指定交易对时检查全部交易对
+		pairs := []string{pair}
+		if pair == "" {
+			pairs = marketPairs()
+		}
+		for _, p := range pairs {
+			if err := checkCancelState(p); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+		}
 
-		// 发布订单到 Redis
-		if err := rc.SubmitOrder(order); err != nil {
-			http.Error(w, "提交订单失败", http.StatusInternalServerError)
-			log.Printf("提交订单到 Redis 失败: %v", err)
+		cmd := EngineCommand{
+			Type:      CommandCancelAll,
+			UserID:    principalFrom(r).UserID,
+			Pair:      pair,
+			Side:      side,
+			RequestID: uuid.New().String(),
+		}
+		if err := rc.SubmitCommand(cmd); err != nil {
+			writeAPIError(w, errSubmitCancel)
+			log.Printf("提交全部撤单到 Redis 失败: %v", err)
+			return
+		}
+		result, err := rc.AwaitCommandResult(cmd.RequestID, 5*time.Second)
+		if err != nil {
+			writeError(w, http.StatusGatewayTimeout, ErrCodeTimeout, "等待撤单结果超时")
+			log.Printf("等待全部撤单结果失败: %v", err)
+			return
+		}
+		if result.Error != "" {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "撤单失败: "+result.Error)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "订单提交成功", "order_id": order.OrderID})
+		json.NewEncoder(w).Encode(map[string][]string{"canceled_order_ids": result.CanceledOrderIDs})
 	}
 }
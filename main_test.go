@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func TestHandleCancelAllHalted(t *testing.T) {
+	rc := newTestRedisClient(t)
+	markets["ETH_USDT"] = MarketConfig{Pair: "ETH_USDT", BaseAsset: "ETH", QuoteAsset: "USDT"}
+	storeMarketState(MarketState{Pair: "ETH_USDT", State: MarketHalted, UpdatedAt: 1})
+	t.Cleanup(func() {
+		delete(markets, "ETH_USDT")
+		marketStatesMu.Lock()
+		delete(marketStates, "ETH_USDT")
+		marketStatesMu.Unlock()
+	})
+
+	// 指定 HALTED 的交易对，或未指定交易对而任一交易对 HALTED 时拒绝，不提交指令
+	for _, target := range []string{"/orders/all?pair=ETH_USDT", "/orders/all", "/orders/all?side=BID"} {
+		w := httptest.NewRecorder()
+		handleCancelAll(rc)(w, httptest.NewRequest(http.MethodDelete, target, nil))
+		var resp ErrorResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusBadRequest || resp.Error.Code != ErrCodeMarketNotTrading {
+			t.Errorf("DELETE %s = %d %s, 期望 400 %s", target, w.Code, resp.Error.Code, ErrCodeMarketNotTrading)
+		}
+	}
+}
+
+func TestValidateOrderPair(t *testing.T) {
+	markets["ETH_USDT"] = MarketConfig{Pair: "ETH_USDT", BaseAsset: "ETH", QuoteAsset: "USDT", TickSize: decimal.New(1, -1), LotSize: decimal.New(1, -2)}
+	t.Cleanup(func() { delete(markets, "ETH_USDT") })
+
+	// 按订单所在交易对的 tick size 和 lot size 校验
+	tests := []struct {
+		pair, price, amount string
+		wantErr             bool
+	}{
+		{"BTC_USDT", "100.05", "0.001", false},
+		{"ETH_USDT", "100.05", "1", true},
+		{"ETH_USDT", "100.1", "0.001", true},
+		{"ETH_USDT", "100.1", "0.01", false},
+		{"DOGE_USDT", "100", "1", true},
+	}
+	for _, tt := range tests {
+		order := Order{
+			OrderID:   uuid.New().String(),
+			UserID:    1,
+			OrderType: "BID",
+			OrderKind: "LIMIT",
+			Price:     decimal.RequireFromString(tt.price),
+			Amount:    decimal.RequireFromString(tt.amount),
+		}
+		if err := validateOrder(tt.pair, &order); (err != nil) != tt.wantErr {
+			t.Errorf("validateOrder(%s, %s x %s) = %v, 期望错误 %v", tt.pair, tt.price, tt.amount, err, tt.wantErr)
+		}
+	}
+	if err := validateOrder("DOGE_USDT", &Order{OrderType: "BID", OrderKind: "LIMIT"}); errorCode(err) != ErrCodeUnsupportedPair {
+		t.Errorf("未配置的交易对返回 %v, 期望 %s", err, ErrCodeUnsupportedPair)
+	}
+}
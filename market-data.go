@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+const tickerWindow = 86400 // 行情统计窗口（秒）
+
+// candleIntervals 支持的 K 线周期（秒）
+var candleIntervals = map[string]int64{
+	"1m":  60,
+	"5m":  300,
+	"15m": 900,
+	"1h":  3600,
+	"4h":  14400,
+	"1d":  86400,
+}
+
+// Ticker 24 小时滚动行情
+type Ticker struct {
+	Pair        string          `json:"pair"`
+	LastPrice   decimal.Decimal `json:"last_price"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Volume      decimal.Decimal `json:"volume"`       // 基础币种成交量
+	QuoteVolume decimal.Decimal `json:"quote_volume"` // 计价币种成交额
+	Change      decimal.Decimal `json:"change"`       // 涨跌额
+	Timestamp   int64           `json:"timestamp"`
+}
+
+// Candle K 线
+type Candle struct {
+	Pair     string          `json:"pair"`
+	Interval string          `json:"interval"`
+	OpenTime int64           `json:"open_time"` // 周期开始时间（秒）
+	Open     decimal.Decimal `json:"open"`
+	High     decimal.Decimal `json:"high"`
+	Low      decimal.Decimal `json:"low"`
+	Close    decimal.Decimal `json:"close"`
+	Volume   decimal.Decimal `json:"volume"`
+}
+
+// marketData 根据成交流维护行情与 K 线
+type marketData struct {
+	mu      sync.Mutex
+	trades  map[string][]Trade            // 窗口内的成交，按时间升序
+	candles map[string]map[string]*Candle // pair -> interval -> 当前 K 线
+	tickers map[string]Ticker             // 最近一笔成交时的行情
+}
+
+var defaultMarketData = &marketData{
+	trades:  make(map[string][]Trade),
+	candles: make(map[string]map[string]*Candle),
+	tickers: make(map[string]Ticker),
+}
+
+// latestTicker 返回最近一笔成交时的行情，尚无成交时只有交易对
+func (md *marketData) latestTicker(pair string) Ticker {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	if ticker, ok := md.tickers[pair]; ok {
+		return ticker
+	}
+	return Ticker{Pair: pair}
+}
+
+// onTrade 记录一笔成交，返回更新后的行情和各周期 K 线
+func (md *marketData) onTrade(trade Trade) (Ticker, []Candle) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	// 淘汰窗口外的成交
+	trades := append(md.trades[trade.Pair], trade)
+	cutoff := trade.Timestamp - tickerWindow
+	start := 0
+	for start < len(trades) && trades[start].Timestamp <= cutoff {
+		start++
+	}
+	trades = trades[start:]
+	md.trades[trade.Pair] = trades
+
+	ticker := Ticker{
+		Pair:        trade.Pair,
+		LastPrice:   trade.Price,
+		Open:        trades[0].Price,
+		High:        trades[0].Price,
+		Low:         trades[0].Price,
+		Volume:      decimal.Zero,
+		QuoteVolume: decimal.Zero,
+		Timestamp:   trade.Timestamp,
+	}
+	for _, t := range trades {
+		if t.Price.GreaterThan(ticker.High) {
+			ticker.High = t.Price
+		}
+		if t.Price.LessThan(ticker.Low) {
+			ticker.Low = t.Price
+		}
+		ticker.Volume = ticker.Volume.Add(t.Amount)
+		ticker.QuoteVolume = ticker.QuoteVolume.Add(t.Price.Mul(t.Amount))
+	}
+	ticker.Change = ticker.LastPrice.Sub(ticker.Open)
+	md.tickers[trade.Pair] = ticker
+
+	pairCandles := md.candles[trade.Pair]
+	if pairCandles == nil {
+		pairCandles = make(map[string]*Candle)
+		md.candles[trade.Pair] = pairCandles
+	}
+	candles := make([]Candle, 0, len(candleIntervals))
+	for interval, seconds := range candleIntervals {
+		openTime := trade.Timestamp - trade.Timestamp%seconds
+		candle := pairCandles[interval]
+		if candle == nil || candle.OpenTime != openTime {
+			// 进入新周期
+			candle = &Candle{
+				Pair:     trade.Pair,
+				Interval: interval,
+				OpenTime: openTime,
+				Open:     trade.Price,
+				High:     trade.Price,
+				Low:      trade.Price,
+				Close:    trade.Price,
+				Volume:   decimal.Zero,
+			}
+			pairCandles[interval] = candle
+		}
+		if trade.Price.GreaterThan(candle.High) {
+			candle.High = trade.Price
+		}
+		if trade.Price.LessThan(candle.Low) {
+			candle.Low = trade.Price
+		}
+		candle.Close = trade.Price
+		candle.Volume = candle.Volume.Add(trade.Amount)
+		candles = append(candles, *candle)
+	}
+
+	return ticker, candles
+}
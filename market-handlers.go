@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultDepthLimit = 100
+	maxDepthLimit     = 1000
+)
+
+// MarketInfo 交易对配置和当前状态
+type MarketInfo struct {
+	MarketConfig
+	State       string `json:"state"`
+	StateReason string `json:"state_reason,omitempty"`
+}
+
+// handleMarkets 处理 GET /markets 请求，返回支持的交易对及其状态
+func handleMarkets(w http.ResponseWriter, r *http.Request) {
+	list := make([]MarketInfo, 0, len(markets))
+	for _, market := range markets {
+		state := currentMarketState(market.Pair)
+		list = append(list, MarketInfo{MarketConfig: market, State: state.State, StateReason: state.Reason})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Pair < list[j].Pair })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]MarketInfo{"markets": list})
+}
+
+// handleDepth 处理 GET /markets/{pair}/depth?limit= 请求，返回聚合盘口快照。
+// update_id 与 WebSocket depth 频道一致，可用于衔接增量
+func handleDepth(w http.ResponseWriter, r *http.Request) {
+	pair := mux.Vars(r)["pair"]
+	if _, ok := getMarket(pair); !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnsupportedPair, "不支持的交易对")
+		return
+	}
+	limit := defaultDepthLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxDepthLimit {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "limit 必须在 1 到 "+strconv.Itoa(maxDepthLimit)+" 之间")
+			return
+		}
+	}
+
+	state := getDepthState(pair)
+	state.mu.Lock()
+	snapshot := state.snapshot(pair)
+	state.mu.Unlock()
+	if len(snapshot.Bids) > limit {
+		snapshot.Bids = snapshot.Bids[:limit]
+	}
+	if len(snapshot.Asks) > limit {
+		snapshot.Asks = snapshot.Asks[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// handleTicker 处理 GET /markets/{pair}/ticker 请求
+func handleTicker(w http.ResponseWriter, r *http.Request) {
+	pair := mux.Vars(r)["pair"]
+	if _, ok := getMarket(pair); !ok {
+		writeError(w, http.StatusNotFound, ErrCodeUnsupportedPair, "不支持的交易对")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(defaultMarketData.latestTicker(pair))
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// 交易对状态
+const (
+	MarketTrading    = "TRADING"
+	MarketHalted     = "HALTED"      // 暂停：不接受下单和撤单，不撮合
+	MarketCancelOnly = "CANCEL_ONLY" // 只接受撤单
+	MarketPostOnly   = "POST_ONLY"   // 只接受不会立即成交的限价单
+	MarketAuction    = "AUCTION"     // 集合竞价：限价单只挂单不撮合，不接受市价单
+)
+
+var validMarketStates = map[string]bool{
+	MarketTrading:    true,
+	MarketHalted:     true,
+	MarketCancelOnly: true,
+	MarketPostOnly:   true,
+	MarketAuction:    true,
+}
+
+// MarketState 交易对当前状态，由撮合引擎修改，保存在 Redis 的 market_states 哈希中
+type MarketState struct {
+	Pair      string `json:"pair"`
+	State     string `json:"state"`
+	Reason    string `json:"reason,omitempty"`
+	Until     int64  `json:"until,omitempty"` // 熔断自动恢复或集合竞价自动撮合的时间（毫秒），0 表示需手动恢复
+	UpdatedAt int64  `json:"updated_at"`      // 毫秒
+}
+
+var (
+	marketStates   = make(map[string]MarketState)
+	marketStatesMu sync.Mutex
+)
+
+// currentMarketState 返回交易对当前状态，未设置过时为 TRADING
+func currentMarketState(pair string) MarketState {
+	marketStatesMu.Lock()
+	defer marketStatesMu.Unlock()
+	if state, ok := marketStates[pair]; ok {
+		return state
+	}
+	return MarketState{Pair: pair, State: MarketTrading}
+}
+
+// storeMarketState 更新内存中的状态，忽略比当前更旧的状态
+func storeMarketState(state MarketState) {
+	marketStatesMu.Lock()
+	defer marketStatesMu.Unlock()
+	if current, ok := marketStates[state.Pair]; ok && current.UpdatedAt > state.UpdatedAt {
+		return
+	}
+	marketStates[state.Pair] = state
+}
+
+// loadMarketStates 启动时从 Redis 恢复各交易对的状态
+func loadMarketStates(rc *RedisClient) error {
+	states, err := rc.LoadMarketStates()
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		log.Printf("交易对 %s 状态: %s %s", state.Pair, state.State, state.Reason)
+		storeMarketState(state)
+	}
+	return nil
+}
+
+// setMarketState 修改交易对状态并推送，只在撮合引擎中调用，保证与订单指令的先后顺序
+func setMarketState(rc *RedisClient, pair, state, reason string, until int64) MarketState {
+	ms := MarketState{Pair: pair, State: state, Reason: reason, Until: until, UpdatedAt: time.Now().UnixMilli()}
+	log.Printf("交易对 %s 状态变更为 %s: %s", pair, state, reason)
+	storeMarketState(ms)
+	if state == MarketTrading {
+		resetBreaker(pair)
+	}
+	if err := rc.SaveMarketState(ms); err != nil {
+		log.Printf("保存交易对状态失败: %v", err)
+	}
+	if err := rc.PublishMarketState(ms); err != nil {
+		log.Printf("发布交易对状态失败: %v", err)
+	}
+	return ms
+}
+
+func errMarketState(state MarketState) *apiError {
+	message := fmt.Sprintf("交易对 %s 当前状态为 %s", state.Pair, state.State)
+	if state.Reason != "" {
+		message += "：" + state.Reason
+	}
+	return newAPIError(http.StatusBadRequest, ErrCodeMarketNotTrading, message)
+}
+
+// checkMarketState 下单时检查交易对状态。POST_ONLY 下限价单是否会立即成交由撮合引擎判断
+func checkMarketState(pair string, order Order) error {
+	state := currentMarketState(pair)
+	switch state.State {
+	case MarketHalted, MarketCancelOnly:
+		return errMarketState(state)
+	case MarketPostOnly, MarketAuction:
+		if order.OrderKind == "MARKET" {
+			return errMarketState(state)
+		}
+	}
+	return nil
+}
+
+// checkCancelState 撤单时检查交易对状态，HALTED 时不接受用户撤单
+func checkCancelState(pair string) error {
+	if state := currentMarketState(pair); state.State == MarketHalted {
+		return errMarketState(state)
+	}
+	return nil
+}
+
+// checkOrderEntry 下单前检查：订单所在交易对 pair 的状态和风控规则链。订单须已落库，exclude 见 checkRisk
+func checkOrderEntry(pc *PostgresClient, rc *RedisClient, pair string, order Order, exclude ...string) error {
+	if err := checkMarketState(pair, order); err != nil {
+		return err
+	}
+	return checkRisk(pc, rc, pair, order, exclude...)
+}
+
+// engineRejectReason 撮合引擎按处理时的交易对状态判断新订单能否进入撮合，不能时返回拒绝原因。
+// 下单接口检查之后、引擎处理之前状态可能已经改变
+func engineRejectReason(rc *RedisClient, pair string, order Order) string {
+	state := currentMarketState(pair)
+	if err := checkMarketState(pair, order); err != nil {
+		return err.Error()
+	}
+	if state.State != MarketPostOnly {
+		return ""
+	}
+	oppositeKey := "asks:" + pair
+	if order.OrderType == "ASK" {
+		oppositeKey = "bids:" + pair
+	}
+	bestPrice, orders, err := rc.GetBestLevel(oppositeKey)
+	if err != nil {
+		log.Printf("获取最优价位失败: %v", err)
+		return "获取对手盘失败"
+	}
+	if len(orders) > 0 && !beyondBound(order.OrderType, bestPrice, order.Price) {
+		return fmt.Sprintf("交易对 %s 当前状态为 POST_ONLY，订单会立即成交", pair)
+	}
+	return ""
+}
+
+// canMatch 撮合前检查交易对状态和熔断，两个撮合函数在每笔成交前调用。
+// 成交价相对时间窗口内的成交价变动超过阈值时暂停交易，该笔不成交
+func canMatch(rc *RedisClient, pair string, price decimal.Decimal) bool {
+	if currentMarketState(pair).State != MarketTrading {
+		return false
+	}
+	market, _ := getMarket(pair)
+	now := time.Now()
+	ref, tripped := checkBreaker(market, price, now)
+	if !tripped {
+		return true
+	}
+	reason := fmt.Sprintf("熔断：成交价 %s 相对 %s 变动超过 %s%%", price, ref, market.BreakerPct.Mul(decimal.NewFromInt(100)))
+	var until int64
+	if market.BreakerHalt > 0 {
+		until = now.Add(time.Duration(market.BreakerHalt) * time.Second).UnixMilli()
+	}
+	setMarketState(rc, pair, MarketHalted, reason, until)
+	return false
+}
+
+type pricePoint struct {
+	at    time.Time
+	price decimal.Decimal
+}
+
+var (
+	breakerPrices   = make(map[string][]pricePoint) // 时间窗口内的成交价，按时间升序
+	breakerPricesMu sync.Mutex
+)
+
+// checkBreaker 价格相对窗口内的最高或最低成交价变动超过阈值时返回 true 和参考价，
+// 未触发时记录该价格
+func checkBreaker(market MarketConfig, price decimal.Decimal, now time.Time) (decimal.Decimal, bool) {
+	if !market.BreakerPct.IsPositive() || market.BreakerWindow <= 0 {
+		return decimal.Zero, false
+	}
+	breakerPricesMu.Lock()
+	defer breakerPricesMu.Unlock()
+
+	points := breakerPrices[market.Pair]
+	cutoff := now.Add(-time.Duration(market.BreakerWindow) * time.Second)
+	for len(points) > 0 && points[0].at.Before(cutoff) {
+		points = points[1:]
+	}
+	one := decimal.NewFromInt(1)
+	for _, p := range points {
+		if price.GreaterThan(p.price.Mul(one.Add(market.BreakerPct))) || price.LessThan(p.price.Mul(one.Sub(market.BreakerPct))) {
+			breakerPrices[market.Pair] = points
+			return p.price, true
+		}
+	}
+	breakerPrices[market.Pair] = append(points, pricePoint{at: now, price: price})
+	return decimal.Zero, false
+}
+
+// resetBreaker 恢复交易时清空窗口，恢复前的价格不再作为参考
+func resetBreaker(pair string) {
+	breakerPricesMu.Lock()
+	delete(breakerPrices, pair)
+	breakerPricesMu.Unlock()
+}
+
+// runMarketStateResume 定期检查熔断和集合竞价是否到期，到期后经撮合引擎恢复交易
+func runMarketStateResume(rc *RedisClient) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	submitted := make(map[string]int64) // pair -> 已提交恢复指令的状态的 UpdatedAt，避免重复提交
+	for range ticker.C {
+		now := time.Now().UnixMilli()
+		for pair := range markets {
+			state := currentMarketState(pair)
+			if state.Until == 0 || now < state.Until || submitted[pair] == state.UpdatedAt {
+				continue
+			}
+			if err := rc.SubmitCommand(EngineCommand{Type: CommandResumeMarket, Pair: pair}); err != nil {
+				log.Printf("提交恢复交易指令失败: %v", err)
+				continue
+			}
+			submitted[pair] = state.UpdatedAt
+		}
+	}
+}
+
+// MarketStateRequest 修改交易对状态的请求
+type MarketStateRequest struct {
+	State    string `json:"state"`
+	Reason   string `json:"reason"`
+	Duration int64  `json:"duration"` // 秒，大于 0 时到期自动恢复交易；集合竞价到期时撮合后恢复
+}
+
+// handleSetMarketState 处理 POST /admin/markets/{pair}/state 请求，
+// 经撮合引擎修改交易对状态，等待处理完成后返回新状态
+func handleSetMarketState(rc *RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pair := mux.Vars(r)["pair"]
+		if _, ok := getMarket(pair); !ok {
+			writeError(w, http.StatusNotFound, ErrCodeUnsupportedPair, "不支持的交易对")
+			return
+		}
+		var req MarketStateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validMarketStates[req.State] || req.Duration < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "无效的交易对状态")
+			return
+		}
+		var until int64
+		if req.Duration > 0 && req.State != MarketTrading {
+			until = time.Now().Add(time.Duration(req.Duration) * time.Second).UnixMilli()
+		}
+
+		cmd := EngineCommand{
+			Type:      CommandSetMarketState,
+			Pair:      pair,
+			State:     req.State,
+			Reason:    req.Reason,
+			Until:     until,
+			RequestID: uuid.New().String(),
+		}
+		if err := rc.SubmitCommand(cmd); err != nil {
+			log.Printf("提交交易对状态指令失败: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "提交指令失败")
+			return
+		}
+		result, err := rc.AwaitCommandResult(cmd.RequestID, 5*time.Second)
+		if err != nil || result.MarketState == nil {
+			writeError(w, http.StatusGatewayTimeout, ErrCodeTimeout, "等待撮合引擎处理超时")
+			return
+		}
+		if result.Error != "" {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "集合竞价撮合失败: "+result.Error)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result.MarketState)
+	}
+}
+
+// broadcastMarketState 推送交易对状态变化到 state:<pair> 频道
+func broadcastMarketState(state MarketState) {
+	storeMarketState(state)
+	publish("state:"+state.Pair, state)
+}
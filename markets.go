@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// MarketConfig 交易对配置
+type MarketConfig struct {
+	Pair        string          `json:"pair"`
+	BaseAsset   string          `json:"base_asset"`   // 基础币种，如 BTC
+	QuoteAsset  string          `json:"quote_asset"`  // 计价币种，如 USDT
+	LotSize     decimal.Decimal `json:"lot_size"`     // 数量的最小变动单位，订单数量和成交数量都是它的整数倍
+	TickSize    decimal.Decimal `json:"tick_size"`    // 价格的最小变动单位，限价单价格和集合竞价计算的成交价都是它的整数倍
+	MaxSlippage decimal.Decimal `json:"max_slippage"` // 市价单未指定保护价和滑点时使用的默认滑点，0 表示不限制
+
+	// 熔断：成交价相对 BreakerWindow 秒内的任一成交价变动超过 BreakerPct 时暂停交易 BreakerHalt 秒，
+	// BreakerHalt 为 0 时需手动恢复。BreakerPct 为 0 表示不启用
+	BreakerPct    decimal.Decimal `json:"breaker_pct"`
+	BreakerWindow int64           `json:"breaker_window"`
+	BreakerHalt   int64           `json:"breaker_halt"`
+
+	// Matching 同一价位挂单的成交分配规则：FIFO、PRO_RATA 或 LMM，为空时为 FIFO，见 MatchingPolicy。
+	// MatchingParams 为 PRO_RATA 和 LMM 的参数，不对外公开
+	Matching       string         `json:"matching"`
+	MatchingParams MatchingParams `json:"-"`
+
+	// ReopenAuction 熔断到期后先进行 ReopenAuction 秒的集合竞价再恢复连续撮合，0 表示直接恢复
+	ReopenAuction int64 `json:"reopen_auction"`
+}
+
+// defaultPair 订单尚未携带交易对，全部进入该交易对的订单簿
+const defaultPair = "BTC_USDT"
+
+// markets 当前支持的交易对
+var markets = map[string]MarketConfig{
+	"BTC_USDT": {
+		Pair:        "BTC_USDT",
+		BaseAsset:   "BTC",
+		QuoteAsset:  "USDT",
+		LotSize:     decimal.New(1, -8),
+		TickSize:    decimal.New(1, -2),
+		MaxSlippage: decimal.New(5, -2),
+		Matching:    "FIFO",
+
+		BreakerPct:    decimal.New(1, -1),
+		BreakerWindow: 300,
+		BreakerHalt:   300,
+		ReopenAuction: 60,
+	},
+}
+
+// getMarket 查询交易对配置
+func getMarket(pair string) (MarketConfig, bool) {
+	market, ok := markets[pair]
+	return market, ok
+}
+
+// marketPairs 返回全部交易对，按名称排序
+func marketPairs() []string {
+	pairs := make([]string, 0, len(markets))
+	for pair := range markets {
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// pairsWithBase 返回基础币种相同的全部交易对，风控按基础币种汇总敞口
+func pairsWithBase(asset string) []string {
+	var pairs []string
+	for _, market := range markets {
+		if market.BaseAsset == asset {
+			pairs = append(pairs, market.Pair)
+		}
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// lotOrDefault lot size 未配置时按 1e-8
+func lotOrDefault(lot decimal.Decimal) decimal.Decimal {
+	if !lot.IsPositive() {
+		return decimal.New(1, -8)
+	}
+	return lot
+}
+
+// floorToLot 向下取整到 lot 的整数倍，lot 未配置时按 1e-8
+func floorToLot(amount, lot decimal.Decimal) decimal.Decimal {
+	lot = lotOrDefault(lot)
+	return amount.Div(lot).Floor().Mul(lot)
+}
+
+// validateMarkets 检查交易对配置，启动时调用
+func validateMarkets() error {
+	for _, market := range markets {
+		if _, err := newMatchingPolicy(market.Matching, market.MatchingParams); err != nil {
+			return fmt.Errorf("交易对 %s: %w", market.Pair, err)
+		}
+	}
+	return nil
+}
+
+// roundToTick 四舍五入到 tick size 的整数倍，tick size 未配置时保留 8 位小数
+func (m MarketConfig) roundToTick(price decimal.Decimal) decimal.Decimal {
+	if !m.TickSize.IsPositive() {
+		return price.Round(8)
+	}
+	return price.Div(m.TickSize).Round(0).Mul(m.TickSize)
+}
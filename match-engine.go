@@ -1,303 +1,340 @@
 package main
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
-	"sort"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
-	"gorm.io/gorm"
 )
 
-// matchOrdersMarket 撮合市价订单
-func matchOrdersMarket(rc *RedisClient, pc *PostgresClient, pair string, newOrder Order) error {
+// matchOrdersMarket 撮合市价订单。按对手盘价格优先依次成交，超出保护价的价位不再成交；
+// 按计价币种下单的买单在预算内按 lot size 向下取整计算每个价位的成交数量，再按分配规则分给挂单
+func matchOrdersMarket(rc *RedisClient, pair string, newOrder Order) error {
 	oppositeKey := "asks:" + pair
 	if newOrder.OrderType == "ASK" {
 		oppositeKey = "bids:" + pair
 	}
+	market, _ := getMarket(pair)
 
 	remainingAmount := newOrder.Amount
-	originalOrder := newOrder
+	byQuote := newOrder.QuoteAmount != nil
+	var remainingQuote decimal.Decimal
+	if byQuote {
+		remainingQuote = *newOrder.QuoteAmount
+	}
+	// remaining 事件中的未成交量，按计价币种下单时为剩余预算
+	remaining := func() decimal.Decimal {
+		if byQuote {
+			return remainingQuote
+		}
+		return remainingAmount
+	}
 
-	// 使用 GORM 事务确保一致性
-	err := pc.db.Transaction(func(tx *gorm.DB) error {
-		for remainingAmount.GreaterThan(decimal.Zero) {
-			// 获取对手盘最佳订单
-			bestOrder, bestPrice, err := rc.GetBestOrder(oppositeKey)
+	var bound decimal.Decimal
+	hasBound, boundSet := false, false
+	filled, complete, stopped := false, false, false
+	stopReason := "对手盘流动性不足"
+
+	// 成交、订单状态和事件随每个价位的订单簿修改写入持久化日志，由 runPersister 异步写入数据库
+	events := &orderEventRecorder{pair: pair}
+	return events.run(rc, func() error {
+		for !complete && !stopped {
+			// 获取对手盘最优价位的全部订单
+			bestPrice, orders, err := rc.GetBestLevel(oppositeKey)
 			if err != nil {
-				log.Printf("获取最佳订单失败: %v", err)
+				log.Printf("获取最优价位失败: %v", err)
 				return err
 			}
-			if bestOrder == nil {
+			if len(orders) == 0 {
 				break // 无可撮合订单
 			}
 
-			// 获取同价格的所有订单，确保 FIFO
-			orders, err := rc.GetOrdersByPrice(oppositeKey, bestPrice)
-			if err != nil {
-				log.Printf("获取同价订单失败: %v", err)
-				return err
+			// 保护价在撮合开始时按对手盘最优价确定
+			if !boundSet {
+				bound, hasBound = protectionBound(newOrder, market, bestPrice)
+				boundSet = true
 			}
-
-			if len(orders) == 0 {
+			if hasBound && beyondBound(newOrder.OrderType, bestPrice, bound) {
+				stopReason = fmt.Sprintf("对手价 %s 超出保护价 %s", bestPrice, bound)
 				break
 			}
 
-			// 按时间戳升序排序（FIFO）
-			sort.Slice(orders, func(i, j int) bool {
-				return orders[i].Timestamp < orders[j].Timestamp
-			})
+			// 按交易对的分配规则分配成交数量
+			levelAmount := remainingAmount
+			if byQuote {
+				levelAmount = affordableAmount(market, remainingQuote, bestPrice)
+			}
+			if !levelAmount.IsPositive() {
+				// 剩余预算在该价位买不到一个 lot，之后的价格只会更差
+				stopReason = "剩余预算不足以成交一个 lot"
+				break
+			}
+			allocations := market.matchingPolicy().Allocate(orders, levelAmount, market.LotSize)
+			if len(allocations) == 0 {
+				break
+			}
 
 			// 撮合订单
-			for _, matchOrder := range orders {
-				if remainingAmount.LessThanOrEqual(decimal.Zero) {
+			var fills []BookFill
+			for _, allocation := range allocations {
+				matchOrder := allocation.Order
+				matchAmount := allocation.Amount
+				tradePrice := matchOrder.Price
+				if !canMatch(rc, pair, tradePrice) {
+					stopReason = "交易对状态为 " + currentMarketState(pair).State
+					stopped = true
 					break
 				}
 
-				// 计算撮合金额
-				matchAmount := min(remainingAmount, matchOrder.Amount)
-				tradePrice := matchOrder.Price
-
 				// 创建交易记录
 				trade := Trade{
 					TradeID:    uuid.New().String(),
+					Pair:       pair,
 					BidOrderID: newOrder.OrderID,
 					AskOrderID: matchOrder.OrderID,
 					Price:      tradePrice,
 					Amount:     matchAmount,
+					Timestamp:  time.Now().Unix(),
 				}
 				if newOrder.OrderType == "ASK" {
 					trade.BidOrderID, trade.AskOrderID = matchOrder.OrderID, newOrder.OrderID
 				}
 
 				// 保存交易
-				if err := pc.SaveTrade(trade); err != nil {
-					log.Printf("保存交易失败: %v", err)
-					return err
-				}
-				if err := rc.PublishTrade(trade); err != nil {
-					log.Printf("发布交易失败: %v", err)
-					return err
-				}
+				events.addTrade(trade)
 
 				// 更新订单
-				remainingAmount = remainingAmount.Sub(matchAmount)
-
-				// 从 Redis 移除匹配订单
-				matchOrderJSON, err := json.Marshal(matchOrder)
-				if err != nil {
-					log.Printf("序列化匹配订单失败: %v", err)
-					return err
+				filled = true
+				if byQuote {
+					remainingQuote = remainingQuote.Sub(tradePrice.Mul(matchAmount))
+					complete = affordableAmount(market, remainingQuote, tradePrice).IsZero()
+				} else {
+					remainingAmount = remainingAmount.Sub(matchAmount)
+					complete = remainingAmount.LessThanOrEqual(decimal.Zero)
 				}
-				if err := rc.RemoveOrder(oppositeKey, string(matchOrderJSON)); err != nil {
-					log.Printf("移除匹配订单失败: %v", err)
-					return err
+				takerStatus := "PARTIALLY_FILLED"
+				if complete {
+					takerStatus = "FILLED"
 				}
+				events.setOrderStatus(newOrder, takerStatus, remaining(), newFill(trade, "TAKER"))
 
+				// 订单簿中的匹配订单在本价位撮合结束后统一更新
+				fills = append(fills, BookFill{Order: matchOrder, Amount: matchAmount})
 				matchOrder.Amount = matchOrder.Amount.Sub(matchAmount)
 
-				// 如果匹配订单有剩余量，重新添加
 				if matchOrder.Amount.GreaterThan(decimal.Zero) {
-					if err := rc.AddOrderToBook(matchOrder, pair); err != nil {
-						log.Printf("重新添加匹配订单失败: %v", err)
-						return err
-					}
-					if err := tx.Table("orders").Where("order_id = ?", matchOrder.OrderID).Update("status", "PARTIALLY_FILLED").Error; err != nil {
-						log.Printf("更新匹配订单状态失败: %v", err)
-						return err
-					}
+					events.setOrderStatus(matchOrder, "PARTIALLY_FILLED", matchOrder.Amount, newFill(trade, "MAKER"))
 				} else {
-					if err := tx.Table("orders").Where("order_id = ?", matchOrder.OrderID).Update("status", "FILLED").Error; err != nil {
-						log.Printf("更新匹配订单状态失败: %v", err)
-						return err
-					}
+					events.setOrderStatus(matchOrder, "FILLED", matchOrder.Amount, newFill(trade, "MAKER"))
+				}
+				if complete {
+					break
 				}
 			}
-		}
 
-		// 更新新订单状态
-		if remainingAmount.LessThanOrEqual(decimal.Zero) {
-			if err := tx.Table("orders").Where("order_id = ?", newOrder.OrderID).Update("status", "FILLED").Error; err != nil {
-				log.Printf("更新新订单状态失败: %v", err)
-				return err
-			}
-		} else if remainingAmount.LessThan(originalOrder.Amount) {
-			if err := tx.Table("orders").Where("order_id = ?", newOrder.OrderID).Update("status", "PARTIALLY_FILLED").Error; err != nil {
-				log.Printf("更新新订单状态失败: %v", err)
-				return err
-			}
-		} else {
-			if err := tx.Table("orders").Where("order_id = ?", newOrder.OrderID).Update("status", "CLOSE").Error; err != nil {
-				log.Printf("更新新订单状态失败: %v", err)
+			// 本价位的挂单变化在一个脚本中写入订单簿
+			if err := events.applyFills(rc, fills, nil); err != nil {
 				return err
 			}
 		}
 
+		// 成交时已逐笔更新新订单状态，完全未成交的市价订单关闭
+		if !filled {
+			events.setOrderStatus(newOrder, "CLOSE", remaining(), nil)
+			events.orders[len(events.orders)-1].Reason = stopReason
+		} else if !complete {
+			events.expire(newOrder, remaining(), stopReason)
+		}
+
 		// 市价订单不添加到订单簿，直接取消剩余部分
-		if remainingAmount.GreaterThan(decimal.Zero) {
-			log.Printf("市价订单剩余量 %v 未撮合，取消剩余部分", remainingAmount)
+		if !complete {
+			log.Printf("市价订单剩余量 %v 未撮合，取消剩余部分: %s", remaining(), stopReason)
 		}
 
 		return nil
 	})
+}
+
+// protectionBound 市价单可成交的最差价格，取保护价和按滑点计算的价格中更严格的一个。
+// 订单未指定滑点时，指定了保护价则只使用保护价，否则使用交易对的默认滑点；都没有时不限制
+func protectionBound(order Order, market MarketConfig, bestPrice decimal.Decimal) (decimal.Decimal, bool) {
+	slippage := market.MaxSlippage
+	if order.MaxSlippage != nil {
+		slippage = *order.MaxSlippage
+	} else if order.ProtectionPrice != nil {
+		slippage = decimal.Zero
+	}
+
+	var bound decimal.Decimal
+	hasBound := false
+	if slippage.IsPositive() {
+		if order.OrderType == "BID" {
+			bound = bestPrice.Mul(decimal.NewFromInt(1).Add(slippage))
+		} else {
+			bound = bestPrice.Mul(decimal.NewFromInt(1).Sub(slippage))
+		}
+		hasBound = true
+	}
+	if order.ProtectionPrice != nil {
+		price := *order.ProtectionPrice
+		if !hasBound || beyondBound(order.OrderType, bound, price) {
+			bound = price
+		}
+		hasBound = true
+	}
+	return bound, hasBound
+}
 
-	if err != nil {
-		return err
+// beyondBound 价格是否比 bound 更差：买单高于、卖单低于
+func beyondBound(side string, price, bound decimal.Decimal) bool {
+	if side == "BID" {
+		return price.GreaterThan(bound)
 	}
+	return price.LessThan(bound)
+}
 
-	return nil
+// affordableAmount 预算在 price 上能买到的数量，向下取整到 lot size，保证成交金额不超过预算
+func affordableAmount(market MarketConfig, budget, price decimal.Decimal) decimal.Decimal {
+	lot := lotOrDefault(market.LotSize)
+	if !budget.IsPositive() {
+		return decimal.Zero
+	}
+	amount := floorToLot(budget.DivRound(price, 18), lot)
+	// 除法舍入后可能恰好多出一个 lot
+	for amount.IsPositive() && amount.Mul(price).GreaterThan(budget) {
+		amount = amount.Sub(lot)
+	}
+	return amount
 }
 
 // matchOrdersPriceLimit 撮合限价订单
-func matchOrdersPriceLimit(rc *RedisClient, pc *PostgresClient, pair string, newOrder Order) error {
+func matchOrdersPriceLimit(rc *RedisClient, pair string, newOrder Order) error {
 	oppositeKey := "asks:" + pair
-	orderKey := "bids:" + pair
 	if newOrder.OrderType == "ASK" {
 		oppositeKey = "bids:" + pair
-		orderKey = "asks:" + pair
 	}
 
+	market, _ := getMarket(pair)
 	remainingAmount := newOrder.Amount
-	originalOrder := newOrder // 保存原始订单用于状态更新
+	halted, rested := false, false
+	// crosses 新订单能否与 price 价位成交
+	crosses := func(price decimal.Decimal) bool {
+		if newOrder.OrderType == "BID" {
+			return price.LessThanOrEqual(newOrder.Price)
+		}
+		return price.GreaterThanOrEqual(newOrder.Price)
+	}
 
-	// 使用 GORM 事务确保数据库一致性
-	err := pc.db.Transaction(func(tx *gorm.DB) error {
-		for remainingAmount.GreaterThan(decimal.Zero) {
-			// 获取对手盘的最佳订单
-			bestOrder, bestPrice, err := rc.GetBestOrder(oppositeKey)
+	// 成交、订单状态和事件随每个价位的订单簿修改写入持久化日志，由 runPersister 异步写入数据库
+	events := &orderEventRecorder{pair: pair}
+	return events.run(rc, func() error {
+		for remainingAmount.GreaterThan(decimal.Zero) && !halted {
+			// 获取对手盘最优价位的全部订单
+			bestPrice, orders, err := rc.GetBestLevel(oppositeKey)
 			if err != nil {
-				log.Printf("获取最佳订单失败: %v", err)
+				log.Printf("获取最优价位失败: %v", err)
 				return err
 			}
-			if bestOrder == nil {
+			if len(orders) == 0 {
 				log.Printf("无可撮合订单")
 				break // 无可撮合订单
 			}
 
 			// 检查价格是否匹配
-			if (newOrder.OrderType == "BID" && bestPrice.GreaterThan(newOrder.Price)) ||
-				(newOrder.OrderType == "ASK" && bestPrice.LessThan(newOrder.Price)) {
+			if !crosses(bestPrice) {
 				break // 价格不匹配，退出
 			}
 
-			// 获取同价格的所有订单，确保 FIFO
-			orders, err := rc.GetOrdersByPrice(oppositeKey, bestPrice)
-			if err != nil {
-				log.Printf("获取同价订单失败: %v", err)
-				return err
-			}
-
-			if len(orders) == 0 {
+			// 按交易对的分配规则分配成交数量
+			allocations := market.matchingPolicy().Allocate(orders, remainingAmount, market.LotSize)
+			if len(allocations) == 0 {
 				break // 无可用订单
 			}
 
-			// 按时间戳升序排序（FIFO）
-			sort.Slice(orders, func(i, j int) bool {
-				return orders[i].Timestamp < orders[j].Timestamp
-			})
-
 			// 撮合订单
-			for _, matchOrder := range orders {
-				if remainingAmount.LessThanOrEqual(decimal.Zero) {
+			var fills []BookFill
+			for _, allocation := range allocations {
+				matchOrder := allocation.Order
+				matchAmount := allocation.Amount
+				tradePrice := matchOrder.Price
+				// 非交易状态或触发熔断时停止撮合，剩余部分挂单
+				if !canMatch(rc, pair, tradePrice) {
+					halted = true
 					break
 				}
 
-				// 计算撮合金额
-				matchAmount := min(remainingAmount, matchOrder.Amount)
-				tradePrice := matchOrder.Price
-
 				// 创建交易记录
 				trade := Trade{
 					TradeID:    uuid.New().String(),
+					Pair:       pair,
 					BidOrderID: newOrder.OrderID,
 					AskOrderID: matchOrder.OrderID,
 					Price:      tradePrice,
 					Amount:     matchAmount,
+					Timestamp:  time.Now().Unix(),
 				}
 				if newOrder.OrderType == "ASK" {
 					trade.BidOrderID, trade.AskOrderID = matchOrder.OrderID, newOrder.OrderID
 				}
 
 				// 保存交易
-				if err := pc.SaveTrade(trade); err != nil {
-					log.Printf("保存交易失败: %v", err)
-					return err
-				}
-				if err := rc.PublishTrade(trade); err != nil {
-					log.Printf("发布交易失败: %v", err)
-					return err
-				}
+				events.addTrade(trade)
 
 				// 更新订单
 				remainingAmount = remainingAmount.Sub(matchAmount)
-
-				// 从 Redis 移除匹配订单
-				matchOrderJSON, err := json.Marshal(matchOrder)
-				if err != nil {
-					log.Printf("序列化匹配订单失败: %v", err)
-					return err
-				}
-				if err := rc.RemoveOrder(oppositeKey, string(matchOrderJSON)); err != nil {
-					log.Printf("移除匹配订单失败: %v", err)
-					return err
+				takerStatus := "PARTIALLY_FILLED"
+				if remainingAmount.LessThanOrEqual(decimal.Zero) {
+					takerStatus = "FILLED"
 				}
+				events.setOrderStatus(newOrder, takerStatus, remainingAmount, newFill(trade, "TAKER"))
 
+				// 订单簿中的匹配订单在本价位撮合结束后统一更新
+				fills = append(fills, BookFill{Order: matchOrder, Amount: matchAmount})
 				matchOrder.Amount = matchOrder.Amount.Sub(matchAmount)
 
-				// 如果匹配订单有剩余量，重新添加
 				if matchOrder.Amount.GreaterThan(decimal.Zero) {
-					if err := rc.AddOrderToBook(matchOrder, pair); err != nil {
-						log.Printf("重新添加匹配订单失败: %v", err)
-						return err
-					}
 					// 更新匹配订单状态为 PARTIALLY_FILLED
-					if err := tx.Table("orders").Where("order_id = ?", matchOrder.OrderID).Update("status", "PARTIALLY_FILLED").Error; err != nil {
-						log.Printf("更新匹配订单状态失败: %v", err)
-						return err
-					}
+					events.setOrderStatus(matchOrder, "PARTIALLY_FILLED", matchOrder.Amount, newFill(trade, "MAKER"))
 				} else {
 					// 更新匹配订单状态为 FILLED
-					if err := tx.Table("orders").Where("order_id = ?", matchOrder.OrderID).Update("status", "FILLED").Error; err != nil {
-						log.Printf("更新匹配订单状态失败: %v", err)
+					events.setOrderStatus(matchOrder, "FILLED", matchOrder.Amount, newFill(trade, "MAKER"))
+				}
+			}
+
+			// 有剩余时本价位已全部成交；停止撮合或下一价位不能成交时，这是最后一个价位
+			last := false
+			if remainingAmount.GreaterThan(decimal.Zero) {
+				last = halted
+				if !last {
+					next, ok, err := rc.NextLevelPrice(oppositeKey, bestPrice)
+					if err != nil {
 						return err
 					}
+					last = !ok || !crosses(next)
 				}
 			}
-		}
 
-		// 更新新订单状态
-		if remainingAmount.LessThanOrEqual(decimal.Zero) {
-			// 新订单完全撮合
-			if err := tx.Table("orders").Where("order_id = ?", newOrder.OrderID).Update("status", "FILLED").Error; err != nil {
-				log.Printf("更新新订单状态失败: %v", err)
-				return err
+			// 本价位的挂单变化在一个脚本中写入订单簿，最后一个价位的剩余部分同时挂单
+			var rest *Order
+			if last {
+				newOrder.Amount = remainingAmount
+				rest = &newOrder
 			}
-		} else {
-			// 从 Redis 移除当前订单状态
-			newOrderJSON, err := json.Marshal(newOrder)
-			if err != nil {
-				log.Printf("序列化新订单失败: %v", err)
+			if err := events.applyFills(rc, fills, rest); err != nil {
 				return err
 			}
-
-			if err := rc.RemoveOrder(orderKey, string(newOrderJSON)); err != nil {
-				log.Printf("移除新订单失败: %v", err)
-				return err
+			if last {
+				rested = true
+				break
 			}
+		}
 
-			// 更新新订单量
+		// 未能与任何价位成交时，整笔订单挂单
+		if remainingAmount.GreaterThan(decimal.Zero) && !rested {
 			newOrder.Amount = remainingAmount
-			if remainingAmount.LessThan(originalOrder.Amount) {
-				// 部分撮合
-				if err := tx.Table("orders").Where("order_id = ?", newOrder.OrderID).Update("status", "PARTIALLY_FILLED").Error; err != nil {
-					log.Printf("更新新订单状态失败: %v", err)
-					return err
-				}
-			}
-
-			// 剩余订单重新添加到订单簿
-			if err := rc.AddOrderToBook(newOrder, pair); err != nil {
+			if err := events.applyFills(rc, nil, &newOrder); err != nil {
 				log.Printf("添加剩余订单失败: %v", err)
 				return err
 			}
@@ -305,12 +342,6 @@ func matchOrdersPriceLimit(rc *RedisClient, pc *PostgresClient, pair string, new
 
 		return nil
 	})
-
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func min(a, b decimal.Decimal) decimal.Decimal {
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// Allocation 同一价位上一笔挂单分到的成交数量
+type Allocation struct {
+	Order  Order
+	Amount decimal.Decimal
+}
+
+// MatchingPolicy 同一价位挂单的成交分配规则，按交易对配置。
+// Allocate 把 amount 分配给价位上的全部挂单 orders，返回按成交顺序排列的非零分配，
+// 合计为 amount 和挂单总量中较小的一个；结果只取决于输入，相同的订单簿总是得到相同的分配
+type MatchingPolicy interface {
+	Name() string
+	Allocate(orders []Order, amount, lot decimal.Decimal) []Allocation
+}
+
+// MatchingParams PRO_RATA 和 LMM 分配规则的参数，含义见 ProRataPolicy 和 LMMPolicy
+type MatchingParams struct {
+	MinAllocation decimal.Decimal
+	TopOrder      bool
+	MakerUserIDs  []int
+	MakerPct      decimal.Decimal
+}
+
+// newMatchingPolicy 按名称创建分配规则，名称为空时为 FIFO
+func newMatchingPolicy(name string, params MatchingParams) (MatchingPolicy, error) {
+	switch name {
+	case "", "FIFO":
+		return FIFOPolicy{}, nil
+	case "PRO_RATA":
+		return ProRataPolicy{MinAllocation: params.MinAllocation}, nil
+	case "LMM":
+		if params.MakerPct.IsNegative() || params.MakerPct.GreaterThan(decimal.NewFromInt(1)) {
+			return nil, fmt.Errorf("LMM 的 MakerPct 必须在 0 到 1 之间")
+		}
+		return LMMPolicy{
+			TopOrder:      params.TopOrder,
+			MakerUserIDs:  params.MakerUserIDs,
+			MakerPct:      params.MakerPct,
+			MinAllocation: params.MinAllocation,
+		}, nil
+	}
+	return nil, fmt.Errorf("未知的分配规则 %q", name)
+}
+
+// matchingPolicy 交易对的分配规则。配置在启动时由 validateMarkets 检查，无效时按 FIFO
+func (m MarketConfig) matchingPolicy() MatchingPolicy {
+	policy, err := newMatchingPolicy(m.Matching, m.MatchingParams)
+	if err != nil {
+		return FIFOPolicy{}
+	}
+	return policy
+}
+
+// FIFOPolicy 时间优先，依次完全成交
+type FIFOPolicy struct{}
+
+func (FIFOPolicy) Name() string { return "FIFO" }
+
+func (FIFOPolicy) Allocate(orders []Order, amount, lot decimal.Decimal) []Allocation {
+	level := newLevel(orders)
+	level.fifo(amount)
+	return level.allocations()
+}
+
+// ProRataPolicy 按挂单数量比例分配，每笔向下取整到 lot size，取整剩下的数量按时间优先分配。
+// 比例分配结果小于 MinAllocation 的订单不参与比例分配，只参与剩余数量的分配
+type ProRataPolicy struct {
+	MinAllocation decimal.Decimal
+}
+
+func (ProRataPolicy) Name() string { return "PRO_RATA" }
+
+func (p ProRataPolicy) Allocate(orders []Order, amount, lot decimal.Decimal) []Allocation {
+	level := newLevel(orders)
+	remaining := amount.Sub(level.proRata(amount, lot, p.MinAllocation))
+	level.fifo(remaining)
+	return level.allocations()
+}
+
+// LMMPolicy 混合分配：价位上最早的订单（价格最优时最先挂出）优先完全成交；
+// 剩余数量的 MakerPct 按比例分给主做市商 MakerUserIDs 的订单；其余按比例分给全部订单，
+// 取整剩下的数量按时间优先分配
+type LMMPolicy struct {
+	TopOrder      bool
+	MakerUserIDs  []int
+	MakerPct      decimal.Decimal
+	MinAllocation decimal.Decimal
+}
+
+func (LMMPolicy) Name() string { return "LMM" }
+
+func (p LMMPolicy) Allocate(orders []Order, amount, lot decimal.Decimal) []Allocation {
+	level := newLevel(orders)
+	remaining := amount
+	if p.TopOrder && len(level) > 0 {
+		remaining = remaining.Sub(level[:1].fifo(remaining))
+	}
+
+	if p.MakerPct.IsPositive() && remaining.IsPositive() {
+		makers := make(map[int]bool, len(p.MakerUserIDs))
+		for _, userID := range p.MakerUserIDs {
+			makers[userID] = true
+		}
+		var makerLevel orderLevel
+		for _, o := range level {
+			if makers[o.order.UserID] {
+				makerLevel = append(makerLevel, o)
+			}
+		}
+		share := floorToLot(remaining.Mul(p.MakerPct), lot)
+		remaining = remaining.Sub(makerLevel.proRata(share, lot, decimal.Zero))
+	}
+
+	remaining = remaining.Sub(level.proRata(remaining, lot, p.MinAllocation))
+	level.fifo(remaining)
+	return level.allocations()
+}
+
+// levelOrder 分配过程中一笔挂单的状态
+type levelOrder struct {
+	order     Order
+	left      decimal.Decimal // 尚未分配的挂单数量
+	allocated decimal.Decimal
+}
+
+// orderLevel 同一价位的挂单，按时间优先排列
+type orderLevel []*levelOrder
+
+// newLevel 按时间优先排列挂单，见 timePriority
+func newLevel(orders []Order) orderLevel {
+	level := make(orderLevel, len(orders))
+	for i, order := range orders {
+		level[i] = &levelOrder{order: order, left: order.Amount, allocated: decimal.Zero}
+	}
+	sort.Slice(level, func(i, j int) bool { return timePriority(level[i].order, level[j].order) })
+	return level
+}
+
+// timePriority a 是否先于 b 成交：按撮合引擎分配的到达序号；没有序号的旧订单在前，
+// 它们之间按时间戳，再按订单号，保证排序结果确定
+func timePriority(a, b Order) bool {
+	if a.Sequence != b.Sequence {
+		return a.Sequence < b.Sequence
+	}
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.OrderID < b.OrderID
+}
+
+// allocate 给一笔挂单分配数量
+func (o *levelOrder) allocate(amount decimal.Decimal) {
+	o.left = o.left.Sub(amount)
+	o.allocated = o.allocated.Add(amount)
+}
+
+// fifo 按时间优先分配 amount，返回实际分配的数量
+func (level orderLevel) fifo(amount decimal.Decimal) decimal.Decimal {
+	total := decimal.Zero
+	for _, o := range level {
+		if !amount.Sub(total).IsPositive() {
+			break
+		}
+		if share := min(amount.Sub(total), o.left); share.IsPositive() {
+			o.allocate(share)
+			total = total.Add(share)
+		}
+	}
+	return total
+}
+
+// proRata 按未分配数量的比例分配 amount，每笔向下取整到 lot size，返回实际分配的数量。
+// amount 不小于未分配总量时全部成交
+func (level orderLevel) proRata(amount, lot, minAllocation decimal.Decimal) decimal.Decimal {
+	open := decimal.Zero
+	for _, o := range level {
+		open = open.Add(o.left)
+	}
+	if !amount.IsPositive() || !open.IsPositive() {
+		return decimal.Zero
+	}
+	if amount.GreaterThanOrEqual(open) {
+		return level.fifo(open)
+	}
+
+	total := decimal.Zero
+	shares := make([]decimal.Decimal, len(level))
+	for i, o := range level {
+		share := floorToLot(amount.Mul(o.left).DivRound(open, 18), lot)
+		if share.LessThan(minAllocation) {
+			continue
+		}
+		shares[i] = share
+	}
+	for i, o := range level {
+		if shares[i].IsPositive() {
+			o.allocate(shares[i])
+			total = total.Add(shares[i])
+		}
+	}
+	return total
+}
+
+// allocations 返回非零分配，按时间优先排列
+func (level orderLevel) allocations() []Allocation {
+	var result []Allocation
+	for _, o := range level {
+		if o.allocated.IsPositive() {
+			result = append(result, Allocation{Order: o.order, Amount: o.allocated})
+		}
+	}
+	return result
+}
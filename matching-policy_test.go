@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// testOrder 挂单：时间戳决定时间优先顺序
+func testOrder(id string, timestamp int64, userID int, amount string) Order {
+	return Order{OrderID: id, Timestamp: timestamp, UserID: userID, Amount: decimal.RequireFromString(amount)}
+}
+
+// formatAllocations 把分配结果格式化为 "id:amount" 列表，便于比较
+func formatAllocations(allocations []Allocation) string {
+	parts := make([]string, len(allocations))
+	for i, a := range allocations {
+		parts[i] = fmt.Sprintf("%s:%s", a.Order.OrderID, a.Amount)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestMatchingPolicyAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy MatchingPolicy
+		orders []Order
+		amount string
+		lot    string // 为空时为 1
+		want   string
+	}{
+		{
+			name:   "FIFO 部分成交",
+			policy: FIFOPolicy{},
+			orders: []Order{testOrder("a", 1, 1, "3"), testOrder("b", 2, 1, "5"), testOrder("c", 3, 1, "2")},
+			amount: "6",
+			want:   "a:3 b:3",
+		},
+		{
+			name:   "FIFO 完全成交",
+			policy: FIFOPolicy{},
+			orders: []Order{testOrder("a", 1, 1, "3"), testOrder("b", 2, 1, "5"), testOrder("c", 3, 1, "2")},
+			amount: "20",
+			want:   "a:3 b:5 c:2",
+		},
+		{
+			name:   "FIFO 按时间戳排序",
+			policy: FIFOPolicy{},
+			orders: []Order{testOrder("c", 3, 1, "2"), testOrder("a", 1, 1, "3"), testOrder("b", 2, 1, "5")},
+			amount: "4",
+			want:   "a:3 b:1",
+		},
+		{
+			name:   "PRO_RATA 按比例",
+			policy: ProRataPolicy{},
+			orders: []Order{testOrder("a", 1, 1, "10"), testOrder("b", 2, 1, "30"), testOrder("c", 3, 1, "60")},
+			amount: "10",
+			want:   "a:1 b:3 c:6",
+		},
+		{
+			name:   "PRO_RATA 取整剩余按时间优先",
+			policy: ProRataPolicy{},
+			orders: []Order{testOrder("a", 1, 1, "10"), testOrder("b", 2, 1, "10"), testOrder("c", 3, 1, "10")},
+			amount: "10",
+			want:   "a:4 b:3 c:3",
+		},
+		{
+			name:   "PRO_RATA 低于最小分配量不参与比例分配",
+			policy: ProRataPolicy{MinAllocation: decimal.NewFromInt(2)},
+			orders: []Order{testOrder("a", 1, 1, "45"), testOrder("b", 2, 1, "40"), testOrder("c", 3, 1, "15")},
+			amount: "10",
+			want:   "a:6 b:4",
+		},
+		{
+			name:   "PRO_RATA 数量不小于挂单总量",
+			policy: ProRataPolicy{},
+			orders: []Order{testOrder("a", 1, 1, "3"), testOrder("b", 2, 1, "2")},
+			amount: "10",
+			want:   "a:3 b:2",
+		},
+		{
+			name:   "PRO_RATA 小数 lot 取整剩余按时间优先",
+			policy: ProRataPolicy{},
+			orders: []Order{testOrder("a", 1, 1, "1"), testOrder("b", 2, 1, "1"), testOrder("c", 3, 1, "1")},
+			amount: "0.01",
+			lot:    "0.001",
+			want:   "a:0.004 b:0.003 c:0.003",
+		},
+		{
+			name:   "PRO_RATA 小数 lot 低于最小分配量",
+			policy: ProRataPolicy{MinAllocation: decimal.RequireFromString("0.002")},
+			orders: []Order{testOrder("a", 1, 1, "0.5"), testOrder("b", 2, 1, "0.3"), testOrder("c", 3, 1, "0.2")},
+			amount: "0.007",
+			lot:    "0.001",
+			want:   "a:0.005 b:0.002",
+		},
+		{
+			name:   "PRO_RATA 小数 lot 取整剩余先给最早的订单，即使低于最小分配量",
+			policy: ProRataPolicy{MinAllocation: decimal.RequireFromString("0.002")},
+			orders: []Order{testOrder("c", 1, 1, "0.2"), testOrder("a", 2, 1, "0.5"), testOrder("b", 3, 1, "0.3")},
+			amount: "0.007",
+			lot:    "0.001",
+			want:   "c:0.002 a:0.003 b:0.002",
+		},
+		{
+			name:   "LMM 最早订单优先",
+			policy: LMMPolicy{TopOrder: true},
+			orders: []Order{testOrder("a", 1, 1, "4"), testOrder("b", 2, 2, "30"), testOrder("c", 3, 3, "30")},
+			amount: "10",
+			want:   "a:4 b:3 c:3",
+		},
+		{
+			name:   "LMM 做市商份额",
+			policy: LMMPolicy{MakerUserIDs: []int{2}, MakerPct: decimal.RequireFromString("0.5")},
+			orders: []Order{testOrder("a", 1, 1, "10"), testOrder("b", 2, 2, "10"), testOrder("c", 3, 3, "20")},
+			amount: "10",
+			want:   "a:3 b:5 c:2",
+		},
+		{
+			name:   "LMM 最早订单、做市商和其它订单",
+			policy: LMMPolicy{TopOrder: true, MakerUserIDs: []int{2}, MakerPct: decimal.RequireFromString("0.4")},
+			orders: []Order{testOrder("a", 1, 2, "2"), testOrder("b", 2, 1, "10"), testOrder("c", 3, 2, "10")},
+			amount: "10",
+			want:   "a:2 b:3 c:5",
+		},
+		{
+			name:   "LMM 数量不小于挂单总量",
+			policy: LMMPolicy{TopOrder: true, MakerUserIDs: []int{2}, MakerPct: decimal.RequireFromString("0.5")},
+			orders: []Order{testOrder("a", 1, 2, "3"), testOrder("b", 2, 1, "2")},
+			amount: "10",
+			want:   "a:3 b:2",
+		},
+		{
+			name: "LMM 小数 lot 和最小分配量",
+			policy: LMMPolicy{
+				MakerUserIDs:  []int{2},
+				MakerPct:      decimal.RequireFromString("0.5"),
+				MinAllocation: decimal.RequireFromString("0.002"),
+			},
+			orders: []Order{testOrder("a", 1, 1, "0.1"), testOrder("b", 2, 2, "0.1"), testOrder("c", 3, 3, "0.8")},
+			amount: "0.009",
+			lot:    "0.001",
+			want:   "a:0.001 b:0.004 c:0.004",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount := decimal.RequireFromString(tt.amount)
+			lot := decimal.NewFromInt(1)
+			if tt.lot != "" {
+				lot = decimal.RequireFromString(tt.lot)
+			}
+			allocations := tt.policy.Allocate(tt.orders, amount, lot)
+			if got := formatAllocations(allocations); got != tt.want {
+				t.Errorf("Allocate = %q, 期望 %q", got, tt.want)
+			}
+
+			// 合计为 amount 和挂单总量中较小的一个
+			open, total := decimal.Zero, decimal.Zero
+			for _, o := range tt.orders {
+				open = open.Add(o.Amount)
+			}
+			for _, a := range allocations {
+				total = total.Add(a.Amount)
+			}
+			if !total.Equal(min(amount, open)) {
+				t.Errorf("分配合计 %s, 期望 %s", total, min(amount, open))
+			}
+		})
+	}
+}
+
+func TestNewMatchingPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   MatchingParams
+		want     string
+		hasError bool
+	}{
+		{name: "", want: "FIFO"},
+		{name: "FIFO", want: "FIFO"},
+		{name: "PRO_RATA", want: "PRO_RATA"},
+		{name: "LMM", params: MatchingParams{MakerPct: decimal.Zero}, want: "LMM"},
+		{name: "LMM", params: MatchingParams{MakerPct: decimal.NewFromInt(1)}, want: "LMM"},
+		{name: "LMM", params: MatchingParams{MakerPct: decimal.RequireFromString("-0.1")}, hasError: true},
+		{name: "LMM", params: MatchingParams{MakerPct: decimal.RequireFromString("1.5")}, hasError: true},
+		{name: "fifo", hasError: true},
+		{name: "UNKNOWN", hasError: true},
+	}
+	for _, tt := range tests {
+		policy, err := newMatchingPolicy(tt.name, tt.params)
+		if tt.hasError {
+			if err == nil {
+				t.Errorf("newMatchingPolicy(%q, MakerPct=%s) 应返回错误", tt.name, tt.params.MakerPct)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newMatchingPolicy(%q, MakerPct=%s) 返回错误: %v", tt.name, tt.params.MakerPct, err)
+		} else if policy.Name() != tt.want {
+			t.Errorf("newMatchingPolicy(%q) = %s, 期望 %s", tt.name, policy.Name(), tt.want)
+		}
+	}
+}
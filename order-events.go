@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 订单事件类型
+const (
+	EventAccepted        = "ACCEPTED"
+	EventPartiallyFilled = "PARTIALLY_FILLED"
+	EventFilled          = "FILLED"
+	EventCanceled        = "CANCELED"
+	EventExpired         = "EXPIRED"  // 市价单未成交部分被丢弃
+	EventRejected        = "REJECTED" // 已落库的订单被撮合引擎拒绝，如改单时原订单已结束
+)
+
+// orderStatusEvents orders 表状态到事件类型的映射
+var orderStatusEvents = map[string]string{
+	"OPEN":             EventAccepted,
+	"PARTIALLY_FILLED": EventPartiallyFilled,
+	"FILLED":           EventFilled,
+	"CANCELED":         EventCanceled,
+	"CLOSE":            EventExpired,
+	"REJECTED":         EventRejected,
+}
+
+// orderEventRecorder 收集一次撮合产生的成交、订单状态变化、订单事件和余额变化。
+// 修改订单簿时通过 applyFills、removeOrder、removeOrders 把此前收集的修改与订单簿修改在同一脚本中写入持久化日志，
+// 之后收集的修改由 commit 单独写入；写入 outbox 的消息由 runOutboxRelay 发布
+type orderEventRecorder struct {
+	pair     string
+	trades   []Trade
+	updates  []orderUpdate
+	orders   []OrderEvent
+	balances []BalanceUpdate
+}
+
+func newOrderEvent(order Order, pair, status string, remaining decimal.Decimal) OrderEvent {
+	return OrderEvent{
+		Event:           orderStatusEvents[status],
+		Status:          status,
+		OrderID:         order.OrderID,
+		UserID:          order.UserID,
+		APIKey:          order.APIKey,
+		Pair:            pair,
+		OrderType:       order.OrderType,
+		OrderKind:       order.OrderKind,
+		Price:           order.Price,
+		RemainingAmount: remaining,
+		Timestamp:       time.Now().Unix(),
+	}
+}
+
+// run 执行 fn，成功后把尚未写入的修改写入持久化日志。fn 失败时已随订单簿修改写入的日志保留，
+// 之后收集的修改（对应未能写入订单簿的价位）丢弃
+func (r *orderEventRecorder) run(rc *RedisClient, fn func() error) error {
+	if err := fn(); err != nil {
+		return err
+	}
+	return r.commit(rc)
+}
+
+// commit 把尚未写入的修改写入持久化日志
+func (r *orderEventRecorder) commit(rc *RedisClient) error {
+	entry, err := r.take()
+	if err != nil {
+		return err
+	}
+	return persist(rc, entry)
+}
+
+// applyFills 修改一个价位的挂单，并在同一脚本中把此前收集的修改写入持久化日志，见 RedisClient.ApplyFills
+func (r *orderEventRecorder) applyFills(rc *RedisClient, fills []BookFill, rest *Order) error {
+	entry, err := r.take()
+	if err != nil {
+		return err
+	}
+	id, err := rc.ApplyFills(r.pair, fills, rest, entry)
+	if err != nil {
+		return err
+	}
+	enqueueJournal(id, entry)
+	return nil
+}
+
+// removeOrder 从订单簿移除订单，并在同一脚本中把此前收集的修改写入持久化日志，见 RedisClient.RemoveOrder
+func (r *orderEventRecorder) removeOrder(rc *RedisClient, redisKey, orderID string) error {
+	entry, err := r.take()
+	if err != nil {
+		return err
+	}
+	id, err := rc.RemoveOrder(redisKey, orderID, entry)
+	if err != nil {
+		return err
+	}
+	enqueueJournal(id, entry)
+	return nil
+}
+
+// removeOrders 从订单簿移除一组订单，并在同一脚本中把此前收集的修改作为一条持久化日志写入，见 RedisClient.RemoveOrders
+func (r *orderEventRecorder) removeOrders(rc *RedisClient, removals []BookRemoval) error {
+	entry, err := r.take()
+	if err != nil {
+		return err
+	}
+	id, err := rc.RemoveOrders(removals, entry)
+	if err != nil {
+		return err
+	}
+	enqueueJournal(id, entry)
+	return nil
+}
+
+// addTrade 记录一笔成交
+func (r *orderEventRecorder) addTrade(trade Trade) {
+	r.trades = append(r.trades, trade)
+}
+
+// setSequence 记录撮合引擎分配的到达序号和接收时间
+func (r *orderEventRecorder) setSequence(order Order) {
+	r.updates = append(r.updates, orderUpdate{OrderID: order.OrderID, Sequence: order.Sequence, ReceivedAt: order.ReceivedAt})
+}
+
+// setOrderStatus 记录订单状态变化和对应事件。fill 非空时附带成交明细和余额变化
+func (r *orderEventRecorder) setOrderStatus(order Order, status string, remaining decimal.Decimal, fill *Fill) {
+	r.updates = append(r.updates, orderUpdate{OrderID: order.OrderID, Status: status})
+	event := newOrderEvent(order, r.pair, status, remaining)
+	event.Fill = fill
+	r.orders = append(r.orders, event)
+	if fill != nil {
+		r.recordBalances(order, *fill)
+	}
+}
+
+// setOrdersStatus 记录一组订单的状态变化，并为每笔订单记录事件
+func (r *orderEventRecorder) setOrdersStatus(orders []Order, status string) {
+	for _, order := range orders {
+		r.updates = append(r.updates, orderUpdate{OrderID: order.OrderID, Status: status})
+		r.orders = append(r.orders, newOrderEvent(order, r.pair, status, order.Amount))
+	}
+}
+
+// expire 记录市价单剩余部分被丢弃，orders 表状态不变
+func (r *orderEventRecorder) expire(order Order, remaining decimal.Decimal, reason string) {
+	event := newOrderEvent(order, r.pair, "CLOSE", remaining)
+	event.Status = ""
+	event.Reason = reason
+	r.orders = append(r.orders, event)
+}
+
+// recordBalances 根据成交计算基础币种和计价币种的变化量
+func (r *orderEventRecorder) recordBalances(order Order, fill Fill) {
+	market, ok := getMarket(r.pair)
+	if !ok {
+		log.Printf("未知交易对 %s，跳过余额事件", r.pair)
+		return
+	}
+	base := fill.Amount
+	quote := fill.Price.Mul(fill.Amount)
+	if order.OrderType == "BID" {
+		quote = quote.Neg()
+	} else {
+		base = base.Neg()
+	}
+	now := time.Now().Unix()
+	r.balances = append(r.balances,
+		BalanceUpdate{UserID: order.UserID, Asset: market.BaseAsset, Delta: base, TradeID: fill.TradeID, Timestamp: now},
+		BalanceUpdate{UserID: order.UserID, Asset: market.QuoteAsset, Delta: quote, TradeID: fill.TradeID, Timestamp: now},
+	)
+}
+
+// take 取出尚未写入的修改并清空，outbox 消息依次为成交、订单事件和余额变化
+func (r *orderEventRecorder) take() (journalEntry, error) {
+	entry := journalEntry{Trades: r.trades, Orders: r.updates}
+	add := func(channel string, payload interface{}) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		entry.Messages = append(entry.Messages, journalMessage{Channel: channel, Payload: data})
+		return nil
+	}
+	for _, trade := range r.trades {
+		if err := add("completed_trades", trade); err != nil {
+			return journalEntry{}, err
+		}
+	}
+	for _, event := range r.orders {
+		if err := add("order_events", event); err != nil {
+			return journalEntry{}, err
+		}
+	}
+	for _, update := range r.balances {
+		if err := add("balance_updates", update); err != nil {
+			return journalEntry{}, err
+		}
+	}
+	r.trades, r.updates, r.orders, r.balances = nil, nil, nil, nil
+	return entry, nil
+}
+
+// newFill 从成交记录构造成交明细
+func newFill(trade Trade, liquidity string) *Fill {
+	return &Fill{
+		TradeID:   trade.TradeID,
+		Price:     trade.Price,
+		Amount:    trade.Amount,
+		Liquidity: liquidity,
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// outboxBatchSize 转发器每次读取的 outbox 行数
+const outboxBatchSize = 100
+
+// outboxPollInterval 没有收到通知时转发器检查 outbox 的间隔
+const outboxPollInterval = time.Second
+
+// outboxNotify 持久化写入 outbox 的事务提交后唤醒转发器
+var outboxNotify = make(chan struct{}, 1)
+
+// notifyOutbox 唤醒转发器，已有未处理的通知时直接返回
+func notifyOutbox() {
+	select {
+	case outboxNotify <- struct{}{}:
+	default:
+	}
+}
+
+// runOutboxRelay 按 id 顺序把已提交的 outbox 消息发布到 Redis。
+// outbox 只由 runPersister 按顺序写入，id 顺序即提交顺序；只能运行一个转发器
+func runOutboxRelay(rc *RedisClient, pc *PostgresClient) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := relayOutbox(rc, pc); err != nil {
+			log.Printf("转发 outbox 消息失败: %v", err)
+		}
+		select {
+		case <-outboxNotify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayOutbox 发布全部未发布的消息。发布和推进 Redis 中的发布进度在一个脚本中完成，
+// 标记 published_at 之前进程退出时，重启后已发布的消息只标记不重复发布
+func relayOutbox(rc *RedisClient, pc *PostgresClient) error {
+	for {
+		var rows []OutboxModel
+		if err := pc.db.Where("published_at IS NULL").Order("id").Limit(outboxBatchSize).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]uint64, 0, len(rows))
+		var relayErr error
+		for _, row := range rows {
+			if err := rc.PublishOutbox(row.ID, row.Channel, row.Payload); err != nil {
+				relayErr = err
+				break
+			}
+			ids = append(ids, row.ID)
+		}
+		if len(ids) > 0 {
+			if err := pc.db.Model(&OutboxModel{}).Where("id IN ?", ids).Update("published_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+		if relayErr != nil || len(rows) < outboxBatchSize {
+			return relayErr
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	journalStream        = "persist_journal" // Redis Stream，每条记录为一个 journalEntry
+	persistQueueSize     = 10000             // 等待写入数据库的日志条数，写满时撮合引擎等待
+	persistBatchSize     = 500               // 一个数据库事务最多写入的日志条数
+	persistRowsPerStmt   = 1000              // 一条 INSERT 或 UPDATE 最多包含的行数
+	persistRetryInterval = time.Second       // 写入数据库失败后的重试间隔
+	persistMaxAttempts   = 3                 // 一批记录最多尝试写入的次数，仍失败时二分查找无法写入的记录
+	journalWaitTimeout   = 2 * time.Second   // 风控检查等待日志写入数据库的最长时间
+)
+
+// journalEntry 一次订单簿修改（一个价位的成交、挂单或撤单）或拒单需要写入数据库的修改
+type journalEntry struct {
+	Trades   []Trade          `json:"trades,omitempty"`
+	Orders   []orderUpdate    `json:"orders,omitempty"`
+	Messages []journalMessage `json:"messages,omitempty"`
+}
+
+// orderUpdate orders 表一行的修改：Status 非空时更新状态，Sequence 非零时更新到达序号和接收时间
+type orderUpdate struct {
+	OrderID    string `json:"order_id"`
+	Status     string `json:"status,omitempty"`
+	Sequence   int64  `json:"sequence,omitempty"`
+	ReceivedAt int64  `json:"received_at,omitempty"`
+}
+
+// journalMessage 写入 outbox 的消息
+type journalMessage struct {
+	Channel string          `json:"channel"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// journalRecord 日志中的一条记录，ID 为 Redis Stream 的条目 ID
+type journalRecord struct {
+	ID    string
+	Entry journalEntry
+}
+
+func (e journalEntry) empty() bool {
+	return len(e.Trades) == 0 && len(e.Orders) == 0 && len(e.Messages) == 0
+}
+
+// persistQueue 已写入日志、等待写入数据库的记录，由 runPersister 按顺序消费
+var persistQueue = make(chan journalRecord, persistQueueSize)
+
+// persist 把不涉及订单簿的修改（如拒单）追加到日志后交给 runPersister 异步写入数据库。
+// 修改订单簿时日志由 RedisClient.ApplyFills 或 RemoveOrder 在同一脚本中追加，之后调用 enqueueJournal。
+// 返回时修改已持久化在日志中，进程退出后由 replayJournal 补写
+func persist(rc *RedisClient, entry journalEntry) error {
+	if entry.empty() {
+		return nil
+	}
+	id, err := rc.AppendJournal(entry)
+	if err != nil {
+		return fmt.Errorf("写入持久化日志失败: %v", err)
+	}
+	enqueueJournal(id, entry)
+	return nil
+}
+
+// enqueueJournal 把已追加到日志的记录交给 runPersister，id 为空表示没有追加
+func enqueueJournal(id string, entry journalEntry) {
+	if id != "" {
+		persistQueue <- journalRecord{ID: id, Entry: entry}
+	}
+}
+
+// journalWritten 已写入数据库的日志进度，updated 在进度更新时关闭并替换
+var journalWritten = struct {
+	sync.Mutex
+	id      string
+	updated chan struct{}
+}{updated: make(chan struct{})}
+
+// setJournalWritten 记录日志已写入数据库到 id，唤醒 waitJournalWritten
+func setJournalWritten(id string) {
+	journalWritten.Lock()
+	defer journalWritten.Unlock()
+	if compareJournalIDs(id, journalWritten.id) > 0 {
+		journalWritten.id = id
+	}
+	close(journalWritten.updated)
+	journalWritten.updated = make(chan struct{})
+}
+
+// waitJournalWritten 等待调用前已追加的日志全部写入数据库，超过 timeout 时返回错误。
+// 读取挂单和持仓的风控规则在查询数据库前调用，避免漏算已撮合但尚未写入数据库的成交和状态变化
+func waitJournalWritten(rc *RedisClient, timeout time.Duration) error {
+	target, err := rc.LastJournalID()
+	if err != nil || target == "" {
+		return err
+	}
+	deadline := time.After(timeout)
+	for {
+		journalWritten.Lock()
+		done := compareJournalIDs(journalWritten.id, target) >= 0
+		updated := journalWritten.updated
+		journalWritten.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			return fmt.Errorf("等待持久化日志 %s 写入数据库超时", target)
+		}
+	}
+}
+
+// compareJournalIDs 按时间和序号比较两个 Stream 条目 ID（<毫秒>-<序号>），空字符串最小
+func compareJournalIDs(a, b string) int {
+	parse := func(id string) (uint64, uint64) {
+		ms, seq, _ := strings.Cut(id, "-")
+		m, _ := strconv.ParseUint(ms, 10, 64)
+		s, _ := strconv.ParseUint(seq, 10, 64)
+		return m, s
+	}
+	am, as := parse(a)
+	bm, bs := parse(b)
+	switch {
+	case am < bm || (am == bm && as < bs):
+		return -1
+	case am == bm && as == bs:
+		return 0
+	}
+	return 1
+}
+
+// replayJournal 启动时把日志中尚未写入数据库的记录补写，需在撮合引擎开始处理指令前调用
+func replayJournal(rc *RedisClient, pc *PostgresClient) error {
+	cursor, err := pc.JournalCursor(journalStream)
+	if err != nil {
+		return err
+	}
+	writer := newJournalWriter(pc)
+	for {
+		records, err := rc.ReadJournal(cursor, persistBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			setJournalWritten(cursor)
+			return nil
+		}
+		log.Printf("补写持久化日志 %d 条", len(records))
+		writer.persist(records)
+		ackJournal(rc, records)
+		cursor = records[len(records)-1].ID
+	}
+}
+
+// runPersister 按日志顺序把记录批量写入数据库，无法写入的记录移入死信表，不阻塞后续记录
+func runPersister(rc *RedisClient, pc *PostgresClient) {
+	writer := newJournalWriter(pc)
+	for record := range persistQueue {
+		batch := []journalRecord{record}
+	drain:
+		for len(batch) < persistBatchSize {
+			select {
+			case record := <-persistQueue:
+				batch = append(batch, record)
+			default:
+				break drain
+			}
+		}
+		writer.persist(batch)
+		setJournalWritten(batch[len(batch)-1].ID)
+		ackJournal(rc, batch)
+	}
+}
+
+// journalWriter 按顺序把日志记录写入数据库。一批记录重试 persistMaxAttempts 次仍失败时二分查找
+// 无法写入的记录，其余记录照常写入，该记录移入死信表并推进日志进度
+type journalWriter struct {
+	write         func(records []journalRecord) error
+	deadLetter    func(record journalRecord, cause error) error
+	retryInterval time.Duration
+}
+
+func newJournalWriter(pc *PostgresClient) *journalWriter {
+	return &journalWriter{
+		write:         func(records []journalRecord) error { return writeJournal(pc, records) },
+		deadLetter:    func(record journalRecord, cause error) error { return deadLetterJournal(pc, record, cause) },
+		retryInterval: persistRetryInterval,
+	}
+}
+
+// persist 写入 records，返回时每条记录都已写入数据库或死信表
+func (w *journalWriter) persist(records []journalRecord) {
+	err := w.writeWithRetry(records)
+	if err == nil {
+		return
+	}
+	if len(records) > 1 {
+		mid := len(records) / 2
+		w.persist(records[:mid])
+		w.persist(records[mid:])
+		return
+	}
+	// 数据库不可用时死信表同样写不进去，继续重试这条记录，避免把正常记录移入死信表
+	for {
+		deadErr := w.deadLetter(records[0], err)
+		if deadErr == nil {
+			log.Printf("持久化日志 %s 无法写入数据库，已移入死信表: %v", records[0].ID, err)
+			return
+		}
+		log.Printf("持久化日志 %s 写入死信表失败，%v 后重试: %v", records[0].ID, w.retryInterval, deadErr)
+		time.Sleep(w.retryInterval)
+		if err = w.writeWithRetry(records); err == nil {
+			return
+		}
+	}
+}
+
+// writeWithRetry 在一个事务内写入 records，失败时最多尝试 persistMaxAttempts 次，返回最后一次的错误
+func (w *journalWriter) writeWithRetry(records []journalRecord) error {
+	var err error
+	for attempt := 1; attempt <= persistMaxAttempts; attempt++ {
+		if err = w.write(records); err == nil {
+			return nil
+		}
+		log.Printf("写入数据库失败（%d 条记录，第 %d 次）: %v", len(records), attempt, err)
+		if attempt < persistMaxAttempts {
+			time.Sleep(w.retryInterval)
+		}
+	}
+	return err
+}
+
+// ackJournal 删除已写入数据库的日志记录。删除失败不影响正确性，补写时按数据库中的进度跳过
+func ackJournal(rc *RedisClient, records []journalRecord) {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	if err := rc.AckJournal(ids...); err != nil {
+		log.Printf("删除持久化日志失败: %v", err)
+	}
+}
+
+// writeJournal 在一个事务内写入一批日志记录：成交和 outbox 使用多行 INSERT，订单状态使用
+// UPDATE ... FROM (VALUES ...)，同时保存日志进度，记录只会被写入一次
+func writeJournal(pc *PostgresClient, records []journalRecord) error {
+	var trades []TradeModel
+	var messages []OutboxModel
+	var updates []orderUpdate
+	for _, record := range records {
+		for _, trade := range record.Entry.Trades {
+			trades = append(trades, newTradeModel(trade))
+		}
+		updates = append(updates, record.Entry.Orders...)
+		for _, message := range record.Entry.Messages {
+			messages = append(messages, OutboxModel{Channel: message.Channel, Payload: string(message.Payload)})
+		}
+	}
+
+	err := pc.db.Transaction(func(tx *gorm.DB) error {
+		if len(trades) > 0 {
+			if err := tx.CreateInBatches(&trades, persistRowsPerStmt).Error; err != nil {
+				return err
+			}
+		}
+		if err := updateOrders(tx, updates); err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			if err := tx.CreateInBatches(&messages, persistRowsPerStmt).Error; err != nil {
+				return err
+			}
+		}
+		cursor := JournalCursorModel{Stream: journalStream, LastID: records[len(records)-1].ID}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cursor).Error
+	})
+	if err != nil {
+		return err
+	}
+	notifyOutbox()
+	return nil
+}
+
+// deadLetterJournal 把无法写入数据库的日志记录保存到死信表，同时推进日志进度
+func deadLetterJournal(pc *PostgresClient, record journalRecord, cause error) error {
+	entry, err := json.Marshal(record.Entry)
+	if err != nil {
+		return err
+	}
+	return pc.db.Transaction(func(tx *gorm.DB) error {
+		dead := JournalDeadLetterModel{Stream: journalStream, EntryID: record.ID, Entry: string(entry), Error: cause.Error()}
+		if err := tx.Create(&dead).Error; err != nil {
+			return err
+		}
+		cursor := JournalCursorModel{Stream: journalStream, LastID: record.ID}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cursor).Error
+	})
+}
+
+// updateOrders 批量更新订单状态和到达序号。同一批中一笔订单的多次状态变化只写入最后一次
+func updateOrders(tx *gorm.DB, updates []orderUpdate) error {
+	statuses := make(map[string]int)
+	var statusRows, sequenceRows [][]interface{}
+	for _, update := range updates {
+		if update.Status != "" {
+			if i, ok := statuses[update.OrderID]; ok {
+				statusRows[i][1] = update.Status
+			} else {
+				statuses[update.OrderID] = len(statusRows)
+				statusRows = append(statusRows, []interface{}{update.OrderID, update.Status})
+			}
+		}
+		if update.Sequence != 0 {
+			sequenceRows = append(sequenceRows, []interface{}{update.OrderID, update.Sequence, update.ReceivedAt})
+		}
+	}
+	if err := updateFromValues(tx, `UPDATE orders AS o SET status = v.status
+		FROM (VALUES %s) AS v(order_id, status) WHERE o.order_id = v.order_id`,
+		"(?::uuid, ?::varchar)", statusRows); err != nil {
+		return err
+	}
+	return updateFromValues(tx, `UPDATE orders AS o SET sequence = v.sequence, received_at = v.received_at
+		FROM (VALUES %s) AS v(order_id, sequence, received_at) WHERE o.order_id = v.order_id`,
+		"(?::uuid, ?::bigint, ?::bigint)", sequenceRows)
+}
+
+// updateFromValues 把 rows 按 persistRowsPerStmt 分组填入 query 的 VALUES 列表执行
+func updateFromValues(tx *gorm.DB, query, row string, rows [][]interface{}) error {
+	for start := 0; start < len(rows); start += persistRowsPerStmt {
+		end := start + persistRowsPerStmt
+		if end > len(rows) {
+			end = len(rows)
+		}
+		values := make([]string, 0, end-start)
+		var args []interface{}
+		for _, r := range rows[start:end] {
+			values = append(values, row)
+			args = append(args, r...)
+		}
+		if err := tx.Exec(fmt.Sprintf(query, strings.Join(values, ", ")), args...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
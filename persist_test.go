@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompareJournalIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1700000000000-0", "1700000000000-0", 0},
+		{"1700000000000-1", "1700000000000-0", 1},
+		{"1700000000000-9", "1700000000000-10", -1},
+		{"999999999999-0", "1000000000000-0", -1},
+		{"1700000000001-0", "1700000000000-5", 1},
+		{"", "1-0", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := compareJournalIDs(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareJournalIDs(%q, %q) = %d, 期望 %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// testJournalRecords 生成 ID 为 1-0 到 n-0 的日志记录
+func testJournalRecords(n int) []journalRecord {
+	records := make([]journalRecord, n)
+	for i := range records {
+		records[i] = journalRecord{ID: fmt.Sprintf("%d-0", i+1)}
+	}
+	return records
+}
+
+func journalIDs(records []journalRecord) string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestJournalWriterDeadLetter(t *testing.T) {
+	// 包含 4-0 的事务总是失败，其余记录按顺序写入，4-0 移入死信表
+	var written, dead []journalRecord
+	attempts := 0
+	writer := &journalWriter{
+		write: func(records []journalRecord) error {
+			attempts++
+			for _, record := range records {
+				if record.ID == "4-0" {
+					return errors.New("bad record")
+				}
+			}
+			written = append(written, records...)
+			return nil
+		},
+		deadLetter: func(record journalRecord, cause error) error {
+			dead = append(dead, record)
+			return nil
+		},
+	}
+	writer.persist(testJournalRecords(7))
+
+	if got := journalIDs(written); got != "1-0,2-0,3-0,5-0,6-0,7-0" {
+		t.Errorf("写入的记录 = %s", got)
+	}
+	if got := journalIDs(dead); got != "4-0" {
+		t.Errorf("死信记录 = %s, 期望 4-0", got)
+	}
+	// 7 条记录二分到单条共 4 层，每层包含 4-0 的一批尝试 persistMaxAttempts 次
+	if attempts > 4*persistMaxAttempts+4 {
+		t.Errorf("写入尝试 %d 次，重试次数没有上限", attempts)
+	}
+}
+
+func TestJournalWriterDatabaseDown(t *testing.T) {
+	// 数据库在前 7 次写入期间不可用，死信表也写不进去，恢复后全部记录写入，不移入死信表
+	var written, dead []journalRecord
+	failures := 7
+	writer := &journalWriter{
+		write: func(records []journalRecord) error {
+			if failures > 0 {
+				failures--
+				return errors.New("connection refused")
+			}
+			written = append(written, records...)
+			return nil
+		},
+		deadLetter: func(record journalRecord, cause error) error {
+			if failures > 0 {
+				return errors.New("connection refused")
+			}
+			dead = append(dead, record)
+			return nil
+		},
+	}
+	writer.persist(testJournalRecords(3))
+
+	if got := journalIDs(written); got != "1-0,2-0,3-0" {
+		t.Errorf("写入的记录 = %s", got)
+	}
+	if len(dead) != 0 {
+		t.Errorf("死信记录 = %s, 期望为空", journalIDs(dead))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -17,13 +18,14 @@ type PostgresClient struct {
 
 // NewPostgresClient 初始化GORM客户端
 func NewPostgresClient() (*PostgresClient, error) {
-	db, err := gorm.Open(postgres.Open(pgConnStr), &gorm.Config{})
+	// TranslateError 将唯一约束冲突转换为 gorm.ErrDuplicatedKey
+	db, err := gorm.Open(postgres.Open(pgConnStr), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, fmt.Errorf("无法连接到 PostgreSQL: %v", err)
 	}
 
 	// 自动迁移数据库结构
-	if err := db.AutoMigrate(&OrderModel{}, &TradeModel{}, &UserModel{}); err != nil {
+	if err := db.AutoMigrate(&OrderModel{}, &TradeModel{}, &UserModel{}, &APIKeyModel{}, &RiskLimitModel{}, &OutboxModel{}, &JournalCursorModel{}, &JournalDeadLetterModel{}); err != nil {
 		return nil, fmt.Errorf("自动迁移失败: %v", err)
 	}
 
@@ -39,15 +41,20 @@ func (pc *PostgresClient) Close() {
 
 // OrderModel 映射到orders表
 type OrderModel struct {
-	OrderID   string `gorm:"primaryKey;type:uuid"`
-	UserID    int    `gorm:"type:integer;foreignKey:UserID;references:users(user_id)"` // 改为整型并添加外键
-	Pair      string `gorm:"type:varchar(20);default:BTC_USDT"`
-	OrderType string `gorm:"type:varchar(4)"`               // 去掉CHECK约束，由应用层验证
-	OrderKind string `gorm:"type:varchar(6);default:LIMIT"` // 去掉CHECK约束，由应用层验证
-	Price     float64
-	Amount    float64
-	Status    string `gorm:"type:varchar(20);default:OPEN"`
-	Timestamp int64  `gorm:"timestamp"`
+	OrderID       string  `gorm:"primaryKey;type:uuid"`
+	UserID        int     `gorm:"type:integer;foreignKey:UserID;references:users(user_id);uniqueIndex:idx_orders_user_client_order_id"` // 改为整型并添加外键
+	ClientOrderID *string `gorm:"type:varchar(64);uniqueIndex:idx_orders_user_client_order_id"`                                         // 为空时不参与唯一约束
+	APIKey        string  `gorm:"type:varchar(64);index"`                                                                               // 下单使用的 API Key
+	Pair          string  `gorm:"type:varchar(20);default:BTC_USDT"`
+	OrderType     string  `gorm:"type:varchar(4)"`               // 去掉CHECK约束，由应用层验证
+	OrderKind     string  `gorm:"type:varchar(6);default:LIMIT"` // 去掉CHECK约束，由应用层验证
+	Price         float64
+	Amount        float64
+	QuoteAmount   float64 // 按计价币种下单的市价买单预算，其它订单为 0
+	Status        string  `gorm:"type:varchar(20);default:OPEN"`
+	Timestamp     int64   `gorm:"timestamp"`
+	Sequence      int64   // 撮合引擎分配的到达序号，尚未进入撮合引擎时为 0
+	ReceivedAt    int64   // 撮合引擎收到订单的时间（纳秒）
 }
 
 // TradeModel 映射到trades表
@@ -60,10 +67,63 @@ type TradeModel struct {
 	Timestamp  int64 `gorm:"timestamp"`
 }
 
+// OutboxModel 映射到outbox表，与成交和订单状态在同一事务内写入的待发布消息，
+// 由 runOutboxRelay 按 id 顺序发布到 Redis。已发布的行保留，用于审计和重放
+type OutboxModel struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	Channel     string `gorm:"type:varchar(64);not null"` // Redis 通道，如 completed_trades
+	Payload     string `gorm:"type:text;not null"`        // JSON 消息
+	CreatedAt   time.Time
+	PublishedAt *time.Time `gorm:"index"` // 尚未发布时为空
+}
+
+// JournalCursorModel 映射到journal_cursors表，记录持久化日志已写入数据库的最后一条 ID，
+// 与该批数据在同一事务内更新
+type JournalCursorModel struct {
+	Stream string `gorm:"primaryKey;type:varchar(64)"`
+	LastID string `gorm:"type:varchar(64);not null"`
+}
+
+// JournalDeadLetterModel 映射到journal_dead_letters表，重试后仍无法写入数据库的持久化日志记录，
+// 保存原始内容和错误，供人工处理
+type JournalDeadLetterModel struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Stream    string `gorm:"type:varchar(64);not null"`
+	EntryID   string `gorm:"type:varchar(64);not null"` // 日志记录 ID
+	Entry     string `gorm:"type:text;not null"`        // journalEntry JSON
+	Error     string `gorm:"type:text;not null"`
+	CreatedAt time.Time
+}
+
 type UserModel struct {
 	UserID int `gorm:"primaryKey;type:integer"`
 }
 
+// APIKeyModel 映射到api_keys表
+type APIKeyModel struct {
+	APIKey    string    `gorm:"primaryKey;type:varchar(64)"`
+	Secret    string    `gorm:"type:varchar(128);not null"`
+	UserID    int       `gorm:"type:integer;not null;index"`
+	User      UserModel `gorm:"foreignKey:UserID;references:UserID"`
+	Scopes    string    `gorm:"type:varchar(64);default:read"` // 逗号分隔，如 read,trade
+	Enabled   bool      `gorm:"default:true"`
+	CreatedAt time.Time
+}
+
+// RiskLimitModel 映射到risk_limits表，下单前风控规则的限额。
+// user_id 为 0 表示所有用户，pair 为空表示所有交易对，同一订单匹配多行时只取最具体的一行：
+// 用户+交易对 > 用户 > 交易对 > 默认。各限额为 0 表示不限制
+type RiskLimitModel struct {
+	ID               uint            `gorm:"primaryKey"`
+	UserID           int             `gorm:"type:integer;not null;default:0;uniqueIndex:idx_risk_limits_user_pair"`
+	Pair             string          `gorm:"type:varchar(20);not null;default:'';uniqueIndex:idx_risk_limits_user_pair"`
+	MaxOrderNotional decimal.Decimal `gorm:"type:numeric;not null;default:0"` // 单笔订单的最大名义价值，计价币种
+	MaxOpenOrders    int             // 该交易对的最大挂单数
+	MaxPosition      decimal.Decimal `gorm:"type:numeric;not null;default:0"` // 基础币种的最大敞口，按已成交持仓加同方向挂单计算
+	PriceCollarPct   decimal.Decimal `gorm:"type:numeric;not null;default:0"` // 限价偏离最新成交价或中间价的最大百分比，如 5 表示 5%
+	UpdatedAt        time.Time
+}
+
 // TableName 指定OrderModel的表名
 func (OrderModel) TableName() string {
 	return "orders"
@@ -74,16 +134,37 @@ func (TradeModel) TableName() string {
 	return "trades"
 }
 
+func (OutboxModel) TableName() string {
+	return "outbox"
+}
+
+func (JournalCursorModel) TableName() string {
+	return "journal_cursors"
+}
+
+func (JournalDeadLetterModel) TableName() string {
+	return "journal_dead_letters"
+}
+
 func (UserModel) TableName() string {
 	return "users"
 }
 
+func (APIKeyModel) TableName() string {
+	return "api_keys"
+}
+
+func (RiskLimitModel) TableName() string {
+	return "risk_limits"
+}
+
 // SaveOrder 保存订单到数据库
 func (pc *PostgresClient) SaveOrder(order Order) error {
 	orderModel := OrderModel{
 		OrderID:   order.OrderID,
 		UserID:    order.UserID,
-		Pair:      "BTC_USDT",
+		APIKey:    order.APIKey,
+		Pair:      defaultPair,
 		OrderType: order.OrderType,
 		OrderKind: order.OrderKind,
 		Price:     order.Price.InexactFloat64(),
@@ -91,20 +172,90 @@ func (pc *PostgresClient) SaveOrder(order Order) error {
 		Status:    "OPEN",
 		Timestamp: order.Timestamp,
 	}
+	if order.QuoteAmount != nil {
+		orderModel.QuoteAmount = order.QuoteAmount.InexactFloat64()
+	}
+	if order.ClientOrderID != "" {
+		orderModel.ClientOrderID = &order.ClientOrderID
+	}
 	return pc.db.Create(&orderModel).Error
 }
 
-// SaveTrade 保存成交到数据库
-func (pc *PostgresClient) SaveTrade(trade Trade) error {
-	tradeModel := TradeModel{
+// GetOrder 按订单 ID 查询订单
+func (pc *PostgresClient) GetOrder(orderID string) (*OrderModel, error) {
+	var orderModel OrderModel
+	if err := pc.db.Where("order_id = ?", orderID).First(&orderModel).Error; err != nil {
+		return nil, err
+	}
+	return &orderModel, nil
+}
+
+// GetOrderTrades 按成交时间查询订单的全部成交
+func (pc *PostgresClient) GetOrderTrades(orderID string) ([]TradeModel, error) {
+	var trades []TradeModel
+	err := pc.db.Where("bid_order_id = ? OR ask_order_id = ?", orderID, orderID).Order("timestamp").Find(&trades).Error
+	return trades, err
+}
+
+// GetOrderByClientOrderID 按用户和客户端订单 ID 查询订单
+func (pc *PostgresClient) GetOrderByClientOrderID(userID int, clientOrderID string) (*OrderModel, error) {
+	var orderModel OrderModel
+	if err := pc.db.Where("user_id = ? AND client_order_id = ?", userID, clientOrderID).First(&orderModel).Error; err != nil {
+		return nil, err
+	}
+	return &orderModel, nil
+}
+
+// UpdateOrderStatus 更新订单状态
+func (pc *PostgresClient) UpdateOrderStatus(orderID, status string) error {
+	return pc.db.Table("orders").Where("order_id = ?", orderID).Update("status", status).Error
+}
+
+// Info 转换为查询结果
+func (m OrderModel) Info() OrderInfo {
+	info := OrderInfo{
+		OrderID:   m.OrderID,
+		UserID:    m.UserID,
+		Pair:      m.Pair,
+		OrderType: m.OrderType,
+		OrderKind: m.OrderKind,
+		Price:     decimal.NewFromFloat(m.Price),
+		Amount:    decimal.NewFromFloat(m.Amount),
+		Status:    m.Status,
+		Timestamp: m.Timestamp,
+	}
+	if m.ClientOrderID != nil {
+		info.ClientOrderID = *m.ClientOrderID
+	}
+	if m.QuoteAmount > 0 {
+		quoteAmount := decimal.NewFromFloat(m.QuoteAmount)
+		info.QuoteAmount = &quoteAmount
+	}
+	return info
+}
+
+// newTradeModel 成交对应的 trades 表记录
+func newTradeModel(trade Trade) TradeModel {
+	return TradeModel{
 		TradeID:    trade.TradeID,
 		BidOrderID: trade.BidOrderID,
 		AskOrderID: trade.AskOrderID,
 		Price:      trade.Price.InexactFloat64(),
 		Amount:     trade.Amount.InexactFloat64(),
-		Timestamp:  time.Now().Unix(),
+		Timestamp:  trade.Timestamp,
+	}
+}
+
+// JournalCursor 持久化日志已写入数据库的最后一条 ID，尚未写入过时为空
+func (pc *PostgresClient) JournalCursor(stream string) (string, error) {
+	var cursors []JournalCursorModel
+	if err := pc.db.Where("stream = ?", stream).Limit(1).Find(&cursors).Error; err != nil {
+		return "", err
+	}
+	if len(cursors) == 0 {
+		return "", nil
 	}
-	return pc.db.Create(&tradeModel).Error
+	return cursors[0].LastID, nil
 }
 
 // ValidateUser 检查 user_id 是否存在于 users 表
@@ -118,3 +269,79 @@ func (pc *PostgresClient) ValidateUser(userID int) error {
 	}
 	return nil
 }
+
+// GetAPIKey 查询 API Key
+func (pc *PostgresClient) GetAPIKey(apiKey string) (*APIKeyModel, error) {
+	var key APIKeyModel
+	if err := pc.db.Where("api_key = ?", apiKey).First(&key).Error; err != nil {
+		return nil, fmt.Errorf("查询 API Key 失败: %v", err)
+	}
+	return &key, nil
+}
+
+// GetRiskLimit 查询适用于用户和交易对的风控限额，没有配置时返回 nil
+func (pc *PostgresClient) GetRiskLimit(userID int, pair string) (*RiskLimitModel, error) {
+	var limits []RiskLimitModel
+	err := pc.db.Where("user_id IN ? AND pair IN ?", []int{0, userID}, []string{"", pair}).
+		Order("user_id DESC, pair DESC").Limit(1).Find(&limits).Error
+	if err != nil || len(limits) == 0 {
+		return nil, err
+	}
+	return &limits[0], nil
+}
+
+// CountOpenOrders 统计用户在交易对上的挂单数，excludeOrderIDs 中的订单不计入
+func (pc *PostgresClient) CountOpenOrders(userID int, pair string, excludeOrderIDs []string) (int64, error) {
+	var count int64
+	query := pc.db.Model(&OrderModel{}).
+		Where("user_id = ? AND pair = ? AND status IN ?", userID, pair, []string{"OPEN", "PARTIALLY_FILLED"})
+	if len(excludeOrderIDs) > 0 {
+		query = query.Where("order_id NOT IN ?", excludeOrderIDs)
+	}
+	return count, query.Count(&count).Error
+}
+
+// Exposure 用户在一个基础币种上的敞口
+type Exposure struct {
+	Position float64 // 已成交的净持仓，买入为正
+	OpenBids float64 // 买单未成交数量
+	OpenAsks float64 // 卖单未成交数量
+}
+
+// GetExposure 汇总用户在 pairs 上的成交持仓和挂单剩余数量，excludeOrderIDs 中的挂单不计入
+func (pc *PostgresClient) GetExposure(userID int, pairs []string, excludeOrderIDs []string) (*Exposure, error) {
+	var exposure Exposure
+	// 自成交时买卖两笔互相抵消
+	err := pc.db.Raw(`SELECT COALESCE(SUM(CASE WHEN o.order_type = 'BID' THEN t.amount ELSE -t.amount END), 0)
+		FROM trades t JOIN orders o ON o.order_id IN (t.bid_order_id, t.ask_order_id)
+		WHERE o.user_id = ? AND o.pair IN ?`, userID, pairs).Scan(&exposure.Position).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var open []struct {
+		OrderType string
+		Remaining float64
+	}
+	sql := `SELECT o.order_type, o.amount - COALESCE(SUM(t.amount), 0) AS remaining
+		FROM orders o LEFT JOIN trades t ON o.order_id IN (t.bid_order_id, t.ask_order_id)
+		WHERE o.user_id = ? AND o.pair IN ? AND o.status IN ?`
+	args := []interface{}{userID, pairs, []string{"OPEN", "PARTIALLY_FILLED"}}
+	if len(excludeOrderIDs) > 0 {
+		sql += " AND o.order_id NOT IN ?"
+		args = append(args, excludeOrderIDs)
+	}
+	sql = "SELECT order_type, SUM(remaining) AS remaining FROM (" + sql +
+		" GROUP BY o.order_id, o.order_type, o.amount) open_orders GROUP BY order_type"
+	if err := pc.db.Raw(sql, args...).Scan(&open).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range open {
+		if row.OrderType == "BID" {
+			exposure.OpenBids = row.Remaining
+		} else {
+			exposure.OpenAsks = row.Remaining
+		}
+	}
+	return &exposure, nil
+}
@@ -0,0 +1,327 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.27.3
+// source: orderbook.proto
+
+// 撮合引擎 gRPC 接口，与 HTTP 接口共用订单校验规则和撮合指令通道。
+// 价格和数量均为十进制字符串，避免浮点误差
+
+package orderbookpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	OrderBookService_PlaceOrder_FullMethodName   = "/orderbook.v1.OrderBookService/PlaceOrder"
+	OrderBookService_CancelOrder_FullMethodName  = "/orderbook.v1.OrderBookService/CancelOrder"
+	OrderBookService_GetOrder_FullMethodName     = "/orderbook.v1.OrderBookService/GetOrder"
+	OrderBookService_StreamTrades_FullMethodName = "/orderbook.v1.OrderBookService/StreamTrades"
+	OrderBookService_StreamDepth_FullMethodName  = "/orderbook.v1.OrderBookService/StreamDepth"
+	OrderBookService_OrderEntry_FullMethodName   = "/orderbook.v1.OrderBookService/OrderEntry"
+)
+
+// OrderBookServiceClient is the client API for OrderBookService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OrderBookServiceClient interface {
+	// 下单，需要 trade 权限
+	PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error)
+	// 撤单，需要 trade 权限
+	CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error)
+	// 查询订单，需要 read 权限
+	GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error)
+	// 成交推送，无需认证
+	StreamTrades(ctx context.Context, in *StreamTradesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Trade], error)
+	// 盘口推送，先推送快照再推送增量，无需认证
+	StreamDepth(ctx context.Context, in *StreamDepthRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DepthUpdate], error)
+	// 双向下单流：客户端发送下单和撤单请求，服务端返回处理结果，并推送该 API Key 下订单的事件。需要 trade 权限
+	OrderEntry(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[OrderEntryRequest, OrderEntryResponse], error)
+}
+
+type orderBookServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrderBookServiceClient(cc grpc.ClientConnInterface) OrderBookServiceClient {
+	return &orderBookServiceClient{cc}
+}
+
+func (c *orderBookServiceClient) PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlaceOrderResponse)
+	err := c.cc.Invoke(ctx, OrderBookService_PlaceOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookServiceClient) CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*CancelOrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelOrderResponse)
+	err := c.cc.Invoke(ctx, OrderBookService_CancelOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookServiceClient) GetOrder(ctx context.Context, in *GetOrderRequest, opts ...grpc.CallOption) (*Order, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Order)
+	err := c.cc.Invoke(ctx, OrderBookService_GetOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderBookServiceClient) StreamTrades(ctx context.Context, in *StreamTradesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Trade], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrderBookService_ServiceDesc.Streams[0], OrderBookService_StreamTrades_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamTradesRequest, Trade]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderBookService_StreamTradesClient = grpc.ServerStreamingClient[Trade]
+
+func (c *orderBookServiceClient) StreamDepth(ctx context.Context, in *StreamDepthRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DepthUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrderBookService_ServiceDesc.Streams[1], OrderBookService_StreamDepth_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamDepthRequest, DepthUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderBookService_StreamDepthClient = grpc.ServerStreamingClient[DepthUpdate]
+
+func (c *orderBookServiceClient) OrderEntry(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[OrderEntryRequest, OrderEntryResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrderBookService_ServiceDesc.Streams[2], OrderBookService_OrderEntry_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[OrderEntryRequest, OrderEntryResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderBookService_OrderEntryClient = grpc.BidiStreamingClient[OrderEntryRequest, OrderEntryResponse]
+
+// OrderBookServiceServer is the server API for OrderBookService service.
+// All implementations must embed UnimplementedOrderBookServiceServer
+// for forward compatibility.
+type OrderBookServiceServer interface {
+	// 下单，需要 trade 权限
+	PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error)
+	// 撤单，需要 trade 权限
+	CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error)
+	// 查询订单，需要 read 权限
+	GetOrder(context.Context, *GetOrderRequest) (*Order, error)
+	// 成交推送，无需认证
+	StreamTrades(*StreamTradesRequest, grpc.ServerStreamingServer[Trade]) error
+	// 盘口推送，先推送快照再推送增量，无需认证
+	StreamDepth(*StreamDepthRequest, grpc.ServerStreamingServer[DepthUpdate]) error
+	// 双向下单流：客户端发送下单和撤单请求，服务端返回处理结果，并推送该 API Key 下订单的事件。需要 trade 权限
+	OrderEntry(grpc.BidiStreamingServer[OrderEntryRequest, OrderEntryResponse]) error
+	mustEmbedUnimplementedOrderBookServiceServer()
+}
+
+// UnimplementedOrderBookServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedOrderBookServiceServer struct{}
+
+func (UnimplementedOrderBookServiceServer) PlaceOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceOrder not implemented")
+}
+func (UnimplementedOrderBookServiceServer) CancelOrder(context.Context, *CancelOrderRequest) (*CancelOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedOrderBookServiceServer) GetOrder(context.Context, *GetOrderRequest) (*Order, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
+}
+func (UnimplementedOrderBookServiceServer) StreamTrades(*StreamTradesRequest, grpc.ServerStreamingServer[Trade]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTrades not implemented")
+}
+func (UnimplementedOrderBookServiceServer) StreamDepth(*StreamDepthRequest, grpc.ServerStreamingServer[DepthUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamDepth not implemented")
+}
+func (UnimplementedOrderBookServiceServer) OrderEntry(grpc.BidiStreamingServer[OrderEntryRequest, OrderEntryResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method OrderEntry not implemented")
+}
+func (UnimplementedOrderBookServiceServer) mustEmbedUnimplementedOrderBookServiceServer() {}
+func (UnimplementedOrderBookServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeOrderBookServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrderBookServiceServer will
+// result in compilation errors.
+type UnsafeOrderBookServiceServer interface {
+	mustEmbedUnimplementedOrderBookServiceServer()
+}
+
+func RegisterOrderBookServiceServer(s grpc.ServiceRegistrar, srv OrderBookServiceServer) {
+	// If the following call pancis, it indicates UnimplementedOrderBookServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&OrderBookService_ServiceDesc, srv)
+}
+
+func _OrderBookService_PlaceOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServiceServer).PlaceOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBookService_PlaceOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServiceServer).PlaceOrder(ctx, req.(*PlaceOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBookService_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServiceServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBookService_CancelOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServiceServer).CancelOrder(ctx, req.(*CancelOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBookService_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderBookServiceServer).GetOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderBookService_GetOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderBookServiceServer).GetOrder(ctx, req.(*GetOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderBookService_StreamTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamTradesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderBookServiceServer).StreamTrades(m, &grpc.GenericServerStream[StreamTradesRequest, Trade]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderBookService_StreamTradesServer = grpc.ServerStreamingServer[Trade]
+
+func _OrderBookService_StreamDepth_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamDepthRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderBookServiceServer).StreamDepth(m, &grpc.GenericServerStream[StreamDepthRequest, DepthUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderBookService_StreamDepthServer = grpc.ServerStreamingServer[DepthUpdate]
+
+func _OrderBookService_OrderEntry_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(OrderBookServiceServer).OrderEntry(&grpc.GenericServerStream[OrderEntryRequest, OrderEntryResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderBookService_OrderEntryServer = grpc.BidiStreamingServer[OrderEntryRequest, OrderEntryResponse]
+
+// OrderBookService_ServiceDesc is the grpc.ServiceDesc for OrderBookService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var OrderBookService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "orderbook.v1.OrderBookService",
+	HandlerType: (*OrderBookServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PlaceOrder",
+			Handler:    _OrderBookService_PlaceOrder_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _OrderBookService_CancelOrder_Handler,
+		},
+		{
+			MethodName: "GetOrder",
+			Handler:    _OrderBookService_GetOrder_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTrades",
+			Handler:       _OrderBookService_StreamTrades_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamDepth",
+			Handler:       _OrderBookService_StreamDepth_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "OrderEntry",
+			Handler:       _OrderBookService_OrderEntry_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "orderbook.proto",
+}
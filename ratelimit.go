@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限流的接口类别，下单和撤单使用独立的令牌桶
+const (
+	LimitOrders  = "orders"
+	LimitCancels = "cancels"
+	LimitQueries = "queries"
+)
+
+const rateLimitIdleTTL = 10 * time.Minute // 空闲令牌桶的回收时间
+
+// rateLimitRule 令牌桶规则：每秒补充 Rate 个令牌，最多累积 Burst 个
+type rateLimitRule struct {
+	Rate  float64
+	Burst float64
+}
+
+// endpointLimits 一类接口分别按 API Key、用户和 IP 限流，Rate 为 0 表示不限
+type endpointLimits struct {
+	PerKey  rateLimitRule
+	PerUser rateLimitRule
+	PerIP   rateLimitRule
+}
+
+var defaultRateLimits = map[string]endpointLimits{
+	LimitOrders: {
+		PerKey:  rateLimitRule{Rate: 10, Burst: 20},
+		PerUser: rateLimitRule{Rate: 20, Burst: 40},
+		PerIP:   rateLimitRule{Rate: 50, Burst: 100},
+	},
+	LimitCancels: {
+		PerKey:  rateLimitRule{Rate: 20, Burst: 40},
+		PerUser: rateLimitRule{Rate: 40, Burst: 80},
+		PerIP:   rateLimitRule{Rate: 100, Burst: 200},
+	},
+	LimitQueries: {
+		PerKey:  rateLimitRule{Rate: 20, Burst: 40},
+		PerUser: rateLimitRule{Rate: 40, Burst: 80},
+		PerIP:   rateLimitRule{Rate: 100, Burst: 200},
+	},
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter 按 类别:维度:标识 维护令牌桶
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]endpointLimits
+	buckets map[string]*tokenBucket
+}
+
+// parseRateLimitRule 解析 "rate:burst" 格式的规则
+func parseRateLimitRule(value string) (rateLimitRule, error) {
+	rateStr, burstStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return rateLimitRule{}, fmt.Errorf("格式应为 rate:burst")
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate < 0 {
+		return rateLimitRule{}, fmt.Errorf("无效的 rate: %s", rateStr)
+	}
+	burst, err := strconv.ParseFloat(burstStr, 64)
+	if err != nil || burst < 1 {
+		return rateLimitRule{}, fmt.Errorf("无效的 burst: %s", burstStr)
+	}
+	return rateLimitRule{Rate: rate, Burst: burst}, nil
+}
+
+// getRateLimits 读取限流配置，环境变量 RATE_LIMIT_<类别>_<KEY|USER|IP>=rate:burst 覆盖默认值，
+// 如 RATE_LIMIT_ORDERS_KEY=5:10
+func getRateLimits() map[string]endpointLimits {
+	limits := make(map[string]endpointLimits, len(defaultRateLimits))
+	for class, l := range defaultRateLimits {
+		for dim, rule := range map[string]*rateLimitRule{"KEY": &l.PerKey, "USER": &l.PerUser, "IP": &l.PerIP} {
+			name := "RATE_LIMIT_" + strings.ToUpper(class) + "_" + dim
+			value := os.Getenv(name)
+			if value == "" {
+				continue
+			}
+			parsed, err := parseRateLimitRule(value)
+			if err != nil {
+				log.Printf("无效的限流配置 %s=%s，使用默认: %v", name, value, err)
+				continue
+			}
+			*rule = parsed
+		}
+		limits[class] = l
+	}
+	return limits
+}
+
+func newRateLimiter(limits map[string]endpointLimits) *rateLimiter {
+	rl := &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+	go rl.cleanup()
+	return rl
+}
+
+// cleanup 定期回收空闲的令牌桶
+func (rl *rateLimiter) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if time.Since(b.last) > rateLimitIdleTTL {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// take 尝试从各维度的令牌桶各取 n 个令牌，任一维度不足时都不扣减。
+// 返回剩余额度最少的维度的剩余量和容量，以及不足时需要等待的时间
+func (rl *rateLimiter) take(class string, ids map[string]string, n float64) (ok bool, remaining, limit float64, retryAfter time.Duration) {
+	l, found := rl.limits[class]
+	if !found {
+		return true, 0, 0, 0
+	}
+	rules := map[string]rateLimitRule{"key": l.PerKey, "user": l.PerUser, "ip": l.PerIP}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	ok = true
+	remaining = math.Inf(1)
+	buckets := make(map[*tokenBucket]bool)
+	for dim, id := range ids {
+		rule := rules[dim]
+		if rule.Rate <= 0 || id == "" {
+			continue
+		}
+		key := class + ":" + dim + ":" + id
+		b, exists := rl.buckets[key]
+		if !exists {
+			b = &tokenBucket{tokens: rule.Burst, last: now}
+			rl.buckets[key] = b
+		}
+		// 按经过的时间补充令牌
+		b.tokens = math.Min(rule.Burst, b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+		b.last = now
+		buckets[b] = true
+
+		if b.tokens < n {
+			ok = false
+			wait := time.Duration((n - b.tokens) / rule.Rate * float64(time.Second))
+			if n > rule.Burst {
+				wait = time.Duration(rule.Burst / rule.Rate * float64(time.Second))
+			}
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+		if b.tokens-n < remaining {
+			remaining, limit = b.tokens-n, rule.Burst
+		}
+	}
+	if math.IsInf(remaining, 1) {
+		return true, 0, 0, 0
+	}
+	if !ok {
+		return false, 0, limit, retryAfter
+	}
+	for b := range buckets {
+		b.tokens -= n
+	}
+	return true, remaining, limit, 0
+}
+
+// clientIP 取请求来源 IP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowRequest 按调用方的 API Key、用户和 IP 扣减 n 个令牌，超限时写入 429 响应并返回 false
+func (rl *rateLimiter) allowRequest(w http.ResponseWriter, r *http.Request, class string, n int) bool {
+	ids := map[string]string{"ip": clientIP(r)}
+	if principal := principalFrom(r); principal != nil {
+		ids["key"] = principal.APIKey
+		ids["user"] = strconv.Itoa(principal.UserID)
+	}
+
+	ok, remaining, limit, retryAfter := rl.take(class, ids, float64(n))
+	if limit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(limit)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Max(remaining, 0))))
+	}
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, ErrCodeRateLimited, "请求过于频繁，请稍后重试")
+		return false
+	}
+	return true
+}
+
+// limit 每个请求扣减 1 个令牌
+func (rl *rateLimiter) limit(class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rl.allowRequest(w, r, class, 1) {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,701 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/shopspring/decimal"
+)
+
+// 订单簿在 Redis 中的结构，redisKey 为 bids:<pair> 或 asks:<pair>：
+//
+//	book:<redisKey>           有序集合，成员为 encodePriceLevel 编码的价位，分值都为 0，按字典序排列
+//	book:<redisKey>:<price>   列表，该价位的订单 ID，按时间优先排列，<price> 见 levelPrice
+//	order:<order_id>          哈希，订单字段，见 orderFields
+//	orders:user:<user_id>     集合，用户在订单簿中的全部订单 ID，见 userOrdersKey
+//	orders:key:<api_key>      集合，该 API Key 下单且在订单簿中的订单 ID，见 apiKeyOrdersKey
+//	orders:session:<id>       集合，带该会话 ID 且在订单簿中的订单 ID，见 sessionOrdersKey
+//
+// 撤单按订单 ID 读取哈希后删除，部分成交只更新哈希中的 amount，订单在价位中的位置不变。
+// 所有修改都由 Lua 脚本完成，各类键同时更新；脚本访问的键全部由调用方计算后经 KEYS 传入，
+// 全部撤单按索引查找订单，不需要扫描订单簿。
+// 撮合由 GetBestLevel 一次读取最优价位的全部订单，价位列表中哈希已不存在的订单 ID 和因此变空的价位同时清理。
+// 之前以订单 JSON 为成员直接放在 bids:<pair>、asks:<pair> 有序集合中，启动时由 MigrateOrderBook 迁移
+
+func bookLevelsKey(redisKey string) string {
+	return "book:" + redisKey
+}
+
+func bookLevelKey(redisKey, price string) string {
+	return "book:" + redisKey + ":" + price
+}
+
+func bookOrderKey(orderID string) string {
+	return "order:" + orderID
+}
+
+// userOrdersKey 用户挂单索引
+func userOrdersKey(userID int) string {
+	return "orders:user:" + strconv.Itoa(userID)
+}
+
+// apiKeyOrdersKey API Key 挂单索引
+func apiKeyOrdersKey(apiKey string) string {
+	return "orders:key:" + apiKey
+}
+
+// sessionOrdersKey 会话挂单索引
+func sessionOrdersKey(sessionID string) string {
+	return "orders:session:" + sessionID
+}
+
+// orderIndexKeys 订单所在的挂单索引：用户索引，以及 API Key 和会话 ID 非空时对应的索引
+func orderIndexKeys(order Order) []string {
+	keys := []string{userOrdersKey(order.UserID)}
+	if order.APIKey != "" {
+		keys = append(keys, apiKeyOrdersKey(order.APIKey))
+	}
+	if order.SessionID != "" {
+		keys = append(keys, sessionOrdersKey(order.SessionID))
+	}
+	return keys
+}
+
+// bookOrderKeys 脚本中一笔挂单占用的 KEYS：订单哈希、价位列表、价位有序集合和 orderIndexKeys，见 luaRemoveOrder 中的 order_keys
+func bookOrderKeys(redisKey string, order Order) []string {
+	keys := []string{bookOrderKey(order.OrderID), bookLevelKey(redisKey, levelPrice(order.Price)), bookLevelsKey(redisKey)}
+	return append(keys, orderIndexKeys(order)...)
+}
+
+// levelPrice 价位列表键中的价格，去掉末尾的 0，同一价格总是得到同一个字符串
+func levelPrice(price decimal.Decimal) string {
+	return price.String()
+}
+
+// 价位编码的整数和小数位数，价格最多 priceFracDigits 位小数、小于 10^priceIntDigits
+const (
+	priceIntDigits  = 24
+	priceFracDigits = 18
+)
+
+// encodePriceLevel 把价格编码为定长字符串：整数部分左侧补 0，小数部分右侧补 0。
+// 编码的字典序与价格的数值顺序一致，价位有序集合用 ZRANGEBYLEX 按价格排序，不经过 float64
+func encodePriceLevel(price decimal.Decimal) (string, error) {
+	if !price.IsPositive() {
+		return "", fmt.Errorf("价格必须大于 0: %s", price)
+	}
+	if !price.Truncate(priceFracDigits).Equal(price) {
+		return "", fmt.Errorf("价格 %s 超过 %d 位小数", price, priceFracDigits)
+	}
+	intPart, fracPart, _ := strings.Cut(price.StringFixed(priceFracDigits), ".")
+	if len(intPart) > priceIntDigits {
+		return "", fmt.Errorf("价格 %s 超出范围", price)
+	}
+	return strings.Repeat("0", priceIntDigits-len(intPart)) + intPart + "." + fracPart, nil
+}
+
+// decodePriceLevel 还原 encodePriceLevel 编码的价格
+func decodePriceLevel(member string) (decimal.Decimal, error) {
+	return decimal.NewFromString(member)
+}
+
+// orderFieldNames 挂单保存的字段，顺序与 orderFields 一致
+var orderFieldNames = []string{"book", "order_id", "client_order_id", "user_id", "api_key", "session_id", "order_type", "order_kind",
+	"price", "amount", "timestamp", "sequence", "received_at"}
+
+// orderFields 挂单保存的字段和值，依次排列，可直接作为 HSET 的参数。
+// 只有限价单会挂单，市价单专用的字段不保存
+func orderFields(redisKey string, order Order) []interface{} {
+	values := []interface{}{redisKey, order.OrderID, order.ClientOrderID, order.UserID, order.APIKey, order.SessionID, order.OrderType, order.OrderKind,
+		order.Price.String(), order.Amount.String(), order.Timestamp, order.Sequence, order.ReceivedAt}
+	fields := make([]interface{}, 0, 2*len(values))
+	for i, name := range orderFieldNames {
+		fields = append(fields, name, values[i])
+	}
+	return fields
+}
+
+// orderFromFields 从哈希字段还原订单，返回订单所在的 redisKey
+func orderFromFields(fields map[string]string) (Order, string, error) {
+	var order Order
+	var err error
+	order.OrderID = fields["order_id"]
+	order.ClientOrderID = fields["client_order_id"]
+	order.APIKey = fields["api_key"]
+	order.SessionID = fields["session_id"]
+	order.OrderType = fields["order_type"]
+	order.OrderKind = fields["order_kind"]
+	if order.UserID, err = strconv.Atoi(fields["user_id"]); err != nil {
+		return order, "", fmt.Errorf("订单 %s 的 user_id 无效: %w", order.OrderID, err)
+	}
+	if order.Price, err = decimal.NewFromString(fields["price"]); err != nil {
+		return order, "", fmt.Errorf("订单 %s 的 price 无效: %w", order.OrderID, err)
+	}
+	if order.Amount, err = decimal.NewFromString(fields["amount"]); err != nil {
+		return order, "", fmt.Errorf("订单 %s 的 amount 无效: %w", order.OrderID, err)
+	}
+	order.Timestamp, _ = strconv.ParseInt(fields["timestamp"], 10, 64)
+	order.Sequence, _ = strconv.ParseInt(fields["sequence"], 10, 64)
+	order.ReceivedAt, _ = strconv.ParseInt(fields["received_at"], 10, 64)
+	return order, fields["book"], nil
+}
+
+// BookFill 一笔挂单在本价位的成交，Order 为成交前的订单
+type BookFill struct {
+	Order  Order
+	Amount decimal.Decimal
+}
+
+// BookRemoval 从 RedisKey 中移除的一笔挂单，Order 为读取到的订单
+type BookRemoval struct {
+	RedisKey string
+	Order    Order
+}
+
+// bookSideKey 订单所在一侧的 redisKey
+func bookSideKey(pair, orderType string) string {
+	if orderType == "ASK" {
+		return "asks:" + pair
+	}
+	return "bids:" + pair
+}
+
+// luaRemoveOrder 脚本公共函数：order_keys 从 KEYS[k] 开始取出一笔挂单的键，依次为订单哈希、价位列表、价位有序集合和 count 个索引，
+// 同时返回下一笔挂单的起始位置；index_order 把订单加入索引；
+// remove_order 删除订单哈希并移出索引，从价位列表移除订单 ID，价位为空时移除价位；
+// append_journal 把持久化日志追加到 Stream，日志为空字符串时不追加，返回条目 ID
+const luaRemoveOrder = `
+local function append_journal(stream, entry)
+	if entry == '' then
+		return ''
+	end
+	return redis.call('XADD', stream, '*', 'entry', entry)
+end
+local function order_keys(k, count)
+	local keys = {order = KEYS[k], level = KEYS[k + 1], levels = KEYS[k + 2], indexes = {}}
+	for j = 1, count do
+		keys.indexes[j] = KEYS[k + 2 + j]
+	end
+	return keys, k + 3 + count
+end
+local function index_order(keys, order_id)
+	for _, key in ipairs(keys.indexes) do
+		redis.call('SADD', key, order_id)
+	end
+end
+local function remove_order(keys, order_id, member)
+	for _, key in ipairs(keys.indexes) do
+		redis.call('SREM', key, order_id)
+	end
+	redis.call('DEL', keys.order)
+	redis.call('LREM', keys.level, 1, order_id)
+	if redis.call('LLEN', keys.level) == 0 then
+		redis.call('ZREM', keys.levels, member)
+	end
+end
+`
+
+// applyFillsScript 原子地执行一个价位的成交，并可选地挂出 taker 的剩余部分，同时追加持久化日志。
+// KEYS[1] 为日志 Stream，ARGV[1] 为日志内容，ARGV[2] 为成交笔数；
+// 每笔成交依次占用 bookOrderKeys 的 KEYS 和 5 个 ARGV（订单 ID、成交前数量、成交后数量、价位成员、索引个数）；
+// 其后如有剩余参数，依次为挂单的订单 ID、价位成员、索引个数和哈希字段，对应最后一组 KEYS。
+// 先校验全部挂单的剩余数量与撮合时读到的一致，不一致时返回错误且不做任何修改。
+// 返回 {日志条目 ID, 成交结果}，成交结果为每笔成交的 {订单 ID, 成交前数量, 成交后数量}，成交后数量为 0 的订单已移除
+var applyFillsScript = redis.NewScript(luaRemoveOrder + `
+local fills = {}
+local k, a = 2, 3
+for i = 1, tonumber(ARGV[2]) do
+	local keys
+	keys, k = order_keys(k, tonumber(ARGV[a + 4]))
+	fills[i] = {keys = keys, order_id = ARGV[a], before = ARGV[a + 1], left = ARGV[a + 2], member = ARGV[a + 3]}
+	a = a + 5
+end
+for _, fill in ipairs(fills) do
+	local current = redis.call('HGET', fill.keys.order, 'amount')
+	if current ~= fill.before then
+		return redis.error_reply('BOOK_CONFLICT 订单 ' .. fill.order_id .. ' 剩余数量为 ' .. tostring(current) .. '，预期 ' .. fill.before)
+	end
+end
+local result = {}
+for _, fill in ipairs(fills) do
+	if fill.left == '0' then
+		remove_order(fill.keys, fill.order_id, fill.member)
+	else
+		redis.call('HSET', fill.keys.order, 'amount', fill.left)
+	end
+	result[#result + 1] = {fill.order_id, fill.before, fill.left}
+end
+if #ARGV >= a then
+	local keys = order_keys(k, tonumber(ARGV[a + 2]))
+	redis.call('HSET', keys.order, unpack(ARGV, a + 3))
+	index_order(keys, ARGV[a])
+	redis.call('RPUSH', keys.level, ARGV[a])
+	redis.call('ZADD', keys.levels, 0, ARGV[a + 1])
+end
+return {append_journal(KEYS[1], ARGV[1]), result}
+`)
+
+// removeOrderScript 原子地移除订单并追加持久化日志。KEYS[1] 为日志 Stream，其后为订单的 bookOrderKeys，
+// ARGV 为日志内容、订单 ID、订单所在的 redisKey、价位成员和索引个数。
+// 订单不在该 redisKey 中时返回 nil 且不追加日志，否则返回 {移除前的剩余数量, 日志条目 ID}
+var removeOrderScript = redis.NewScript(luaRemoveOrder + `
+local keys = order_keys(2, tonumber(ARGV[5]))
+if redis.call('HGET', keys.order, 'book') ~= ARGV[3] then
+	return false
+end
+local amount = redis.call('HGET', keys.order, 'amount')
+remove_order(keys, ARGV[2], ARGV[4])
+return {amount, append_journal(KEYS[1], ARGV[1])}
+`)
+
+// removeOrdersScript 原子地移除一组订单并追加一条持久化日志。KEYS[1] 为日志 Stream，ARGV[1] 为日志内容，ARGV[2] 为订单笔数；
+// 每笔订单依次占用 bookOrderKeys 的 KEYS 和 5 个 ARGV（订单 ID、所在的 redisKey、剩余数量、价位成员、索引个数）。
+// 先校验全部订单仍在所在的 redisKey 中且剩余数量与读取时一致，不一致时返回错误且不做任何修改。返回日志条目 ID
+var removeOrdersScript = redis.NewScript(luaRemoveOrder + `
+local orders = {}
+local k, a = 2, 3
+for i = 1, tonumber(ARGV[2]) do
+	local keys
+	keys, k = order_keys(k, tonumber(ARGV[a + 4]))
+	orders[i] = {keys = keys, order_id = ARGV[a], book = ARGV[a + 1], amount = ARGV[a + 2], member = ARGV[a + 3]}
+	a = a + 5
+end
+for _, order in ipairs(orders) do
+	local fields = redis.call('HMGET', order.keys.order, 'book', 'amount')
+	if fields[1] ~= order.book or fields[2] ~= order.amount then
+		return redis.error_reply('BOOK_CONFLICT 订单 ' .. order.order_id .. ' 已不在订单簿中或剩余数量已变化')
+	end
+end
+for _, order in ipairs(orders) do
+	remove_order(order.keys, order.order_id, order.member)
+end
+return append_journal(KEYS[1], ARGV[1])
+`)
+
+// bestLevelScript 读取价位上的订单。KEYS 为价位有序集合、价位列表和各订单的哈希，ARGV 为价位成员和各订单 ID，
+// 与订单哈希一一对应。哈希已不存在的订单 ID 从列表中移除，列表因此为空时同时移除价位，返回其余订单的哈希字段，顺序不变
+var bestLevelScript = redis.NewScript(`
+local result = {}
+for i = 2, #ARGV do
+	local fields = redis.call('HGETALL', KEYS[i + 1])
+	if #fields == 0 then
+		redis.call('LREM', KEYS[2], 1, ARGV[i])
+	else
+		result[#result + 1] = fields
+	end
+end
+if redis.call('LLEN', KEYS[2]) == 0 then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return result
+`)
+
+// ApplyFills 在一个 Lua 脚本中执行一个价位上的全部成交，rest 非空时同时挂出 taker 的剩余部分，
+// 并把 entry 追加到持久化日志，订单簿不会停在成交了一半的状态，也不会有修改了订单簿却没有日志的状态。
+// 挂单已被修改时返回错误，订单簿和日志保持不变。返回日志条目 ID，entry 为空时为空字符串
+func (rc *RedisClient) ApplyFills(pair string, fills []BookFill, rest *Order, entry journalEntry) (string, error) {
+	if len(fills) == 0 && rest == nil {
+		return "", nil
+	}
+	data, err := journalData(entry)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, 6*len(fills)+7)
+	args := make([]interface{}, 0, 5*len(fills)+2*len(orderFieldNames)+5)
+	keys = append(keys, journalStream)
+	args = append(args, data, len(fills))
+	for _, fill := range fills {
+		redisKey := bookSideKey(pair, fill.Order.OrderType)
+		member, err := encodePriceLevel(fill.Order.Price)
+		if err != nil {
+			return "", err
+		}
+		left := fill.Order.Amount.Sub(fill.Amount)
+		if !left.IsPositive() {
+			left = decimal.Zero
+		}
+		orderKeys := bookOrderKeys(redisKey, fill.Order)
+		keys = append(keys, orderKeys...)
+		args = append(args, fill.Order.OrderID, fill.Order.Amount.String(), left.String(), member, len(orderKeys)-3)
+	}
+	if rest != nil {
+		redisKey := bookSideKey(pair, rest.OrderType)
+		member, err := encodePriceLevel(rest.Price)
+		if err != nil {
+			log.Printf("无效价格: %v", err)
+			return "", err
+		}
+		log.Printf("添加订单 %s 到 %s, 价格: %v, 序号: %d", rest.OrderID, redisKey, rest.Price, rest.Sequence)
+		orderKeys := bookOrderKeys(redisKey, *rest)
+		keys = append(keys, orderKeys...)
+		args = append(args, rest.OrderID, member, len(orderKeys)-3)
+		args = append(args, orderFields(redisKey, *rest)...)
+	}
+
+	reply, err := applyFillsScript.Run(rc.ctx, rc.client, keys, args...).Result()
+	if err != nil {
+		log.Printf("更新订单簿失败: %v", err)
+		return "", err
+	}
+	var journalID string
+	var results []interface{}
+	if values, _ := reply.([]interface{}); len(values) == 2 {
+		journalID = fmt.Sprint(values[0])
+		results, _ = values[1].([]interface{})
+	}
+
+	// 成交结果与 fills 一一对应
+	for i, r := range results {
+		fields, _ := r.([]interface{})
+		if i >= len(fills) || len(fields) != 3 {
+			continue
+		}
+		before, _ := decimal.NewFromString(fmt.Sprint(fields[1]))
+		left, _ := decimal.NewFromString(fmt.Sprint(fields[2]))
+		rc.notifyBookChange(bookSideKey(pair, fills[i].Order.OrderType), fills[i].Order.Price, left.Sub(before))
+	}
+	if rest != nil {
+		rc.notifyBookChange(bookSideKey(pair, rest.OrderType), rest.Price, rest.Amount)
+	}
+	return journalID, nil
+}
+
+// AddOrderToBook 把订单添加到所在价位的末尾，不写入持久化日志
+func (rc *RedisClient) AddOrderToBook(order Order, pair string) error {
+	_, err := rc.ApplyFills(pair, nil, &order, journalEntry{})
+	return err
+}
+
+// bestLevel 最优价位：买盘取最高价，卖盘取最低价。订单簿为空时返回空字符串
+func (rc *RedisClient) bestLevel(redisKey string) (string, error) {
+	levels := rc.client.ZRangeByLex
+	if strings.HasPrefix(redisKey, "bids:") {
+		levels = rc.client.ZRevRangeByLex
+	}
+	members, err := levels(rc.ctx, bookLevelsKey(redisKey), &redis.ZRangeBy{Min: "-", Max: "+", Count: 1}).Result()
+	if err != nil || len(members) == 0 {
+		return "", err
+	}
+	return members[0], nil
+}
+
+// NextLevelPrice 价格劣于 price 的下一个价位：买盘为更低的价格，卖盘为更高的价格。没有时 ok 为 false
+func (rc *RedisClient) NextLevelPrice(redisKey string, price decimal.Decimal) (next decimal.Decimal, ok bool, err error) {
+	member, err := encodePriceLevel(price)
+	if err != nil {
+		return decimal.Zero, false, err
+	}
+	var members []string
+	if strings.HasPrefix(redisKey, "bids:") {
+		members, err = rc.client.ZRevRangeByLex(rc.ctx, bookLevelsKey(redisKey), &redis.ZRangeBy{Min: "-", Max: "(" + member, Count: 1}).Result()
+	} else {
+		members, err = rc.client.ZRangeByLex(rc.ctx, bookLevelsKey(redisKey), &redis.ZRangeBy{Min: "(" + member, Max: "+", Count: 1}).Result()
+	}
+	if err != nil || len(members) == 0 {
+		return decimal.Zero, false, err
+	}
+	next, err = decodePriceLevel(members[0])
+	return next, err == nil, err
+}
+
+// GetBestLevel 返回最优价位的价格和该价位上的全部订单，订单按时间优先排列，订单簿为空时 orders 为空。
+// 价位的订单由一个脚本读取；订单已全部失效的价位在同一脚本中移除，之后继续读取下一个价位
+func (rc *RedisClient) GetBestLevel(redisKey string) (decimal.Decimal, []Order, error) {
+	for {
+		level, err := rc.bestLevel(redisKey)
+		if err != nil || level == "" {
+			return decimal.Zero, nil, err
+		}
+		price, err := decodePriceLevel(level)
+		if err != nil {
+			log.Printf("解析价位失败: %v", err)
+			return decimal.Zero, nil, err
+		}
+		levelKey := bookLevelKey(redisKey, levelPrice(price))
+		orderIDs, err := rc.client.LRange(rc.ctx, levelKey, 0, -1).Result()
+		if err != nil {
+			log.Printf("获取同价订单失败: %v", err)
+			return decimal.Zero, nil, err
+		}
+		keys := make([]string, 0, len(orderIDs)+2)
+		keys = append(keys, bookLevelsKey(redisKey), levelKey)
+		args := make([]interface{}, 0, len(orderIDs)+1)
+		args = append(args, level)
+		for _, orderID := range orderIDs {
+			keys = append(keys, bookOrderKey(orderID))
+			args = append(args, orderID)
+		}
+		reply, err := bestLevelScript.Run(rc.ctx, rc.client, keys, args...).Result()
+		if err != nil {
+			log.Printf("获取同价订单失败: %v", err)
+			return decimal.Zero, nil, err
+		}
+		hashes, _ := reply.([]interface{})
+		orders := make([]Order, 0, len(hashes))
+		for _, hash := range hashes {
+			values, _ := hash.([]interface{})
+			fields := make(map[string]string, len(values)/2)
+			for i := 0; i+1 < len(values); i += 2 {
+				fields[fmt.Sprint(values[i])] = fmt.Sprint(values[i+1])
+			}
+			order, _, err := orderFromFields(fields)
+			if err != nil {
+				log.Printf("解析订单失败: %v", err)
+				continue
+			}
+			orders = append(orders, order)
+		}
+		if len(hashes) > 0 {
+			return price, orders, nil
+		}
+		log.Printf("移除已失效的价位 %s %s", redisKey, price)
+	}
+}
+
+// RemoveOrder 按订单 ID 从订单簿移除订单，并在同一脚本中把 entry 追加到持久化日志，返回日志条目 ID。
+// 订单不在 redisKey 中时忽略，entry 也不写入，返回空字符串
+func (rc *RedisClient) RemoveOrder(redisKey, orderID string, entry journalEntry) (string, error) {
+	order, err := rc.FindOrder(redisKey, orderID)
+	if err != nil || order == nil {
+		return "", err
+	}
+	member, err := encodePriceLevel(order.Price)
+	if err != nil {
+		return "", err
+	}
+	data, err := journalData(entry)
+	if err != nil {
+		return "", err
+	}
+	orderKeys := bookOrderKeys(redisKey, *order)
+	keys := append([]string{journalStream}, orderKeys...)
+	reply, err := removeOrderScript.Run(rc.ctx, rc.client, keys, data, orderID, redisKey, member, len(orderKeys)-3).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 2 {
+		return "", fmt.Errorf("移除订单 %s 的返回值无效: %v", orderID, reply)
+	}
+	amount, err := decimal.NewFromString(fmt.Sprint(values[0]))
+	if err != nil {
+		amount = order.Amount
+	}
+	rc.notifyBookChange(redisKey, order.Price, amount.Neg())
+	return fmt.Sprint(values[1]), nil
+}
+
+// RemoveOrders 在一个 Lua 脚本中移除一组订单，并把 entry 作为一条持久化日志追加，返回日志条目 ID。
+// 任一订单已不在订单簿中或剩余数量已变化时返回错误，订单簿和日志保持不变
+func (rc *RedisClient) RemoveOrders(removals []BookRemoval, entry journalEntry) (string, error) {
+	if len(removals) == 0 {
+		return "", nil
+	}
+	data, err := journalData(entry)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, 6*len(removals)+1)
+	args := make([]interface{}, 0, 5*len(removals)+2)
+	keys = append(keys, journalStream)
+	args = append(args, data, len(removals))
+	for _, removal := range removals {
+		member, err := encodePriceLevel(removal.Order.Price)
+		if err != nil {
+			return "", err
+		}
+		orderKeys := bookOrderKeys(removal.RedisKey, removal.Order)
+		keys = append(keys, orderKeys...)
+		args = append(args, removal.Order.OrderID, removal.RedisKey, removal.Order.Amount.String(), member, len(orderKeys)-3)
+	}
+	reply, err := removeOrdersScript.Run(rc.ctx, rc.client, keys, args...).Result()
+	if err != nil {
+		log.Printf("移除订单失败: %v", err)
+		return "", err
+	}
+	for _, removal := range removals {
+		rc.notifyBookChange(removal.RedisKey, removal.Order.Price, removal.Order.Amount.Neg())
+	}
+	return fmt.Sprint(reply), nil
+}
+
+// loadOrders 批量读取订单哈希，跳过已不存在或无法解析的订单
+func (rc *RedisClient) loadOrders(orderIDs []string) ([]Order, error) {
+	orders, _, err := rc.loadBookOrders(orderIDs)
+	return orders, err
+}
+
+// loadBookOrders 同 loadOrders，同时返回每笔订单所在的 redisKey
+func (rc *RedisClient) loadBookOrders(orderIDs []string) ([]Order, []string, error) {
+	if len(orderIDs) == 0 {
+		return []Order{}, nil, nil
+	}
+	cmds := make([]*redis.StringStringMapCmd, len(orderIDs))
+	_, err := rc.client.Pipelined(rc.ctx, func(pipe redis.Pipeliner) error {
+		for i, orderID := range orderIDs {
+			cmds[i] = pipe.HGetAll(rc.ctx, bookOrderKey(orderID))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("读取订单失败: %v", err)
+		return nil, nil, err
+	}
+	orders := make([]Order, 0, len(orderIDs))
+	books := make([]string, 0, len(orderIDs))
+	for i, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			log.Printf("订单 %s 不存在", orderIDs[i])
+			continue
+		}
+		order, book, err := orderFromFields(cmd.Val())
+		if err != nil {
+			log.Printf("解析订单失败: %v", err)
+			continue
+		}
+		orders = append(orders, order)
+		books = append(books, book)
+	}
+	return orders, books, nil
+}
+
+// GetAllOrders 返回订单簿一侧的全部订单，价格从低到高，同价位按时间优先
+func (rc *RedisClient) GetAllOrders(redisKey string) ([]Order, error) {
+	levels, err := rc.client.ZRangeByLex(rc.ctx, bookLevelsKey(redisKey), &redis.ZRangeBy{Min: "-", Max: "+"}).Result()
+	if err != nil {
+		log.Printf("获取所有订单失败: %v", err)
+		return nil, err
+	}
+	orders := make([]Order, 0)
+	for _, level := range levels {
+		price, err := decodePriceLevel(level)
+		if err != nil {
+			log.Printf("解析价位失败: %v", err)
+			return nil, err
+		}
+		orderIDs, err := rc.client.LRange(rc.ctx, bookLevelKey(redisKey, levelPrice(price)), 0, -1).Result()
+		if err != nil {
+			log.Printf("获取所有订单失败: %v", err)
+			return nil, err
+		}
+		levelOrders, err := rc.loadOrders(orderIDs)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, levelOrders...)
+	}
+	return orders, nil
+}
+
+// FindOrder 按订单 ID 查找订单簿中的订单，订单不存在或不在 redisKey 中时返回 nil
+func (rc *RedisClient) FindOrder(redisKey, orderID string) (*Order, error) {
+	fields, err := rc.client.HGetAll(rc.ctx, bookOrderKey(orderID)).Result()
+	if err != nil {
+		log.Printf("查找订单失败: %v", err)
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	order, book, err := orderFromFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	if book != redisKey {
+		return nil, nil
+	}
+	return &order, nil
+}
+
+// orderFilter 按用户查找订单时的附加条件，字段为空时不过滤
+type orderFilter struct {
+	APIKey    string // 只返回该 API Key 下的订单
+	SessionID string // 只返回带该会话 ID 的订单
+}
+
+// FindOrdersByUser 按索引查找订单簿中属于该用户且满足 filter 的全部订单，使用条件最窄的索引。
+// 返回 redisKey 到订单的映射，同一 redisKey 中的订单按到达顺序排列
+func (rc *RedisClient) FindOrdersByUser(userID int, filter orderFilter) (map[string][]Order, error) {
+	indexKey := userOrdersKey(userID)
+	if filter.SessionID != "" {
+		indexKey = sessionOrdersKey(filter.SessionID)
+	} else if filter.APIKey != "" {
+		indexKey = apiKeyOrdersKey(filter.APIKey)
+	}
+	orderIDs, err := rc.client.SMembers(rc.ctx, indexKey).Result()
+	if err != nil {
+		log.Printf("查找用户订单失败: %v", err)
+		return nil, err
+	}
+	orders, books, err := rc.loadBookOrders(orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string][]Order)
+	for i, order := range orders {
+		if order.UserID == userID && (filter.APIKey == "" || order.APIKey == filter.APIKey) &&
+			(filter.SessionID == "" || order.SessionID == filter.SessionID) {
+			found[books[i]] = append(found[books[i]], order)
+		}
+	}
+	for _, list := range found {
+		sort.SliceStable(list, func(i, j int) bool { return timePriority(list[i], list[j]) })
+	}
+	return found, nil
+}
+
+// MigrateOrderBook 迁移旧结构的订单簿，没有旧数据时不做任何事：
+// 以订单 JSON 为成员的 bids:<pair>、asks:<pair> 有序集合迁移到按订单 ID 保存的结构，同价位按时间优先排列，
+// 完成后删除旧的有序集合
+func (rc *RedisClient) MigrateOrderBook(pair string) error {
+	for _, redisKey := range []string{"bids:" + pair, "asks:" + pair} {
+		keyType, err := rc.client.Type(rc.ctx, redisKey).Result()
+		if err != nil {
+			return err
+		}
+		if keyType != "zset" {
+			continue
+		}
+		members, err := rc.client.ZRange(rc.ctx, redisKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		orders := make([]Order, 0, len(members))
+		for _, member := range members {
+			var order Order
+			if err := json.Unmarshal([]byte(member), &order); err != nil {
+				log.Printf("迁移时解析订单失败: %v, 成员: %s", err, member)
+				continue
+			}
+			orders = append(orders, order)
+		}
+		sort.SliceStable(orders, func(i, j int) bool { return timePriority(orders[i], orders[j]) })
+		for _, order := range orders {
+			// 上次迁移中断时已迁移的订单不重复添加
+			exists, err := rc.client.Exists(rc.ctx, bookOrderKey(order.OrderID)).Result()
+			if err != nil {
+				return err
+			}
+			if exists > 0 {
+				continue
+			}
+			if err := rc.AddOrderToBook(order, pair); err != nil {
+				return fmt.Errorf("迁移订单 %s 失败: %w", order.OrderID, err)
+			}
+		}
+		if err := rc.client.Del(rc.ctx, redisKey).Err(); err != nil {
+			return err
+		}
+		log.Printf("已迁移 %s 中的 %d 笔订单", redisKey, len(orders))
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// newTestRedisClient 连接到测试结束时关闭的内存 Redis
+func newTestRedisClient(t *testing.T) *RedisClient {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &RedisClient{client: client, ctx: context.Background()}
+}
+
+// levelOrderPrices 按数值升序排列，覆盖整数位数变化、18 位小数和 24 位整数的最大价格
+var levelOrderPrices = []string{
+	"0.000000000000000001",
+	"0.1",
+	"0.999999999999999999",
+	"1",
+	"1.000000000000000001",
+	"9.99",
+	"10",
+	"99.999999999999999999",
+	"100",
+	"123456789.12345678",
+	"999999999999999999999999.999999999999999999",
+}
+
+func TestEncodePriceLevel(t *testing.T) {
+	tests := []struct {
+		price string
+		want  string
+	}{
+		{"123456789.12345678", "000000000000000123456789.123456780000000000"},
+		{"0.000000000000000001", "000000000000000000000000.000000000000000001"},
+		{"1", "000000000000000000000001.000000000000000000"},
+		{"999999999999999999999999.999999999999999999", "999999999999999999999999.999999999999999999"},
+	}
+	for _, tt := range tests {
+		price := decimal.RequireFromString(tt.price)
+		member, err := encodePriceLevel(price)
+		if err != nil {
+			t.Errorf("encodePriceLevel(%s) 返回错误: %v", tt.price, err)
+			continue
+		}
+		if member != tt.want {
+			t.Errorf("encodePriceLevel(%s) = %s, 期望 %s", tt.price, member, tt.want)
+		}
+		decoded, err := decodePriceLevel(member)
+		if err != nil || !decoded.Equal(price) {
+			t.Errorf("decodePriceLevel(%s) = %s, %v, 期望 %s", member, decoded, err, tt.price)
+		}
+	}
+}
+
+func TestEncodePriceLevelRejects(t *testing.T) {
+	for _, price := range []string{
+		"0",
+		"-1",
+		"0.0000000000000000001",       // 19 位小数
+		"1.1234567890123456789",       // 19 位小数
+		"1000000000000000000000000",   // 25 位整数
+		"9999999999999999999999999.5", // 25 位整数
+	} {
+		if member, err := encodePriceLevel(decimal.RequireFromString(price)); err == nil {
+			t.Errorf("encodePriceLevel(%s) = %s, 应返回错误", price, member)
+		}
+	}
+}
+
+func TestEncodePriceLevelOrder(t *testing.T) {
+	// 按数值升序排列
+	prices := []string{
+		"0.000000000000000001",
+		"0.09",
+		"0.1",
+		"0.999999999999999999",
+		"1",
+		"1.49999",
+		"1.5",
+		"9",
+		"10",
+		"99.99",
+		"100",
+		"123456789.12345678",
+		"999999999999999999999999.999999999999999999",
+	}
+	members := make([]string, len(prices))
+	for i, price := range prices {
+		member, err := encodePriceLevel(decimal.RequireFromString(price))
+		if err != nil {
+			t.Fatalf("encodePriceLevel(%s) 返回错误: %v", price, err)
+		}
+		members[i] = member
+	}
+	for i := 1; i < len(members); i++ {
+		if members[i-1] >= members[i] {
+			t.Errorf("%s 的编码 %s 不小于 %s 的编码 %s", prices[i-1], members[i-1], prices[i], members[i])
+		}
+	}
+}
+
+func TestBookLevelOrder(t *testing.T) {
+	for _, side := range []string{"BID", "ASK"} {
+		t.Run(side, func(t *testing.T) {
+			rc := newTestRedisClient(t)
+			redisKey := "bids:BTC_USDT"
+			if side == "ASK" {
+				redisKey = "asks:BTC_USDT"
+			}
+			// 乱序挂单，价位顺序只取决于编码
+			for _, i := range []int{5, 0, 10, 6, 3, 8, 1, 9, 4, 2, 7} {
+				order := Order{
+					OrderID:   fmt.Sprintf("order-%d", i),
+					UserID:    1,
+					OrderType: side,
+					OrderKind: "LIMIT",
+					Price:     decimal.RequireFromString(levelOrderPrices[i]),
+					Amount:    decimal.NewFromInt(1),
+				}
+				if err := rc.AddOrderToBook(order, "BTC_USDT"); err != nil {
+					t.Fatalf("AddOrderToBook(%s) 返回错误: %v", levelOrderPrices[i], err)
+				}
+			}
+
+			orders, err := rc.GetAllOrders(redisKey)
+			if err != nil {
+				t.Fatalf("GetAllOrders 返回错误: %v", err)
+			}
+			if len(orders) != len(levelOrderPrices) {
+				t.Fatalf("GetAllOrders 返回 %d 笔订单, 期望 %d", len(orders), len(levelOrderPrices))
+			}
+			for i, order := range orders {
+				if !order.Price.Equal(decimal.RequireFromString(levelOrderPrices[i])) {
+					t.Errorf("GetAllOrders 第 %d 笔价格 %s, 期望 %s", i, order.Price, levelOrderPrices[i])
+				}
+			}
+
+			// 买盘从最高价、卖盘从最低价依次取出最优价位，下一价位为次优价格
+			ordered := make([]string, len(levelOrderPrices))
+			for n := range levelOrderPrices {
+				ordered[n] = levelOrderPrices[n]
+				if side == "BID" {
+					ordered[n] = levelOrderPrices[len(levelOrderPrices)-1-n]
+				}
+			}
+			for n, price := range ordered {
+				want := decimal.RequireFromString(price)
+				best, levelOrders, err := rc.GetBestLevel(redisKey)
+				if err != nil || len(levelOrders) != 1 {
+					t.Fatalf("GetBestLevel = %s, %v, %v, 期望价格 %s 的 1 笔订单", best, levelOrders, err, want)
+				}
+				if !best.Equal(want) || !levelOrders[0].Price.Equal(want) {
+					t.Fatalf("GetBestLevel 价格 %s, 期望 %s", best, want)
+				}
+				next, ok, err := rc.NextLevelPrice(redisKey, best)
+				if err != nil {
+					t.Fatalf("NextLevelPrice(%s) 返回错误: %v", best, err)
+				}
+				if n == len(ordered)-1 {
+					if ok {
+						t.Errorf("NextLevelPrice(%s) = %s, 期望没有下一价位", best, next)
+					}
+				} else if !ok || !next.Equal(decimal.RequireFromString(ordered[n+1])) {
+					t.Errorf("NextLevelPrice(%s) = %s, %v, 期望 %s", best, next, ok, ordered[n+1])
+				}
+				if _, err := rc.RemoveOrder(redisKey, levelOrders[0].OrderID, journalEntry{}); err != nil {
+					t.Fatalf("RemoveOrder(%s) 返回错误: %v", levelOrders[0].OrderID, err)
+				}
+			}
+			if _, levelOrders, err := rc.GetBestLevel(redisKey); err != nil || len(levelOrders) != 0 {
+				t.Errorf("订单簿已空, GetBestLevel = %v, %v", levelOrders, err)
+			}
+		})
+	}
+}
+
+func TestGetBestLevelPrunesStaleOrders(t *testing.T) {
+	rc := newTestRedisClient(t)
+	for i, price := range []string{"10", "10", "9.99", "9.99"} {
+		order := Order{
+			OrderID:   fmt.Sprintf("order-%d", i),
+			UserID:    1,
+			OrderType: "ASK",
+			OrderKind: "LIMIT",
+			Price:     decimal.RequireFromString(price),
+			Amount:    decimal.NewFromInt(1),
+		}
+		if err := rc.AddOrderToBook(order, "BTC_USDT"); err != nil {
+			t.Fatalf("AddOrderToBook 返回错误: %v", err)
+		}
+	}
+	// 9.99 价位的订单哈希都已不存在，10 价位只剩 order-1
+	rc.client.Del(rc.ctx, bookOrderKey("order-0"), bookOrderKey("order-2"), bookOrderKey("order-3"))
+
+	price, orders, err := rc.GetBestLevel("asks:BTC_USDT")
+	if err != nil || !price.Equal(decimal.NewFromInt(10)) || len(orders) != 1 || orders[0].OrderID != "order-1" {
+		t.Fatalf("GetBestLevel = %s, %v, %v, 期望价格 10 的 order-1", price, orders, err)
+	}
+	if n := rc.client.ZCard(rc.ctx, bookLevelsKey("asks:BTC_USDT")).Val(); n != 1 {
+		t.Errorf("失效的价位应被移除, 剩余 %d 个价位", n)
+	}
+	if ids := rc.client.LRange(rc.ctx, bookLevelKey("asks:BTC_USDT", "10"), 0, -1).Val(); len(ids) != 1 || ids[0] != "order-1" {
+		t.Errorf("价位 10 的订单 ID 为 %v, 期望 [order-1]", ids)
+	}
+}
+
+func TestBookOrderIndexes(t *testing.T) {
+	rc := newTestRedisClient(t)
+	orders := []Order{
+		{OrderID: "a", UserID: 1, APIKey: "key-1", SessionID: "s-1", Sequence: 1},
+		{OrderID: "b", UserID: 1, APIKey: "key-1", Sequence: 2},
+		{OrderID: "c", UserID: 1, Sequence: 3},
+	}
+	for i := range orders {
+		orders[i].OrderType, orders[i].OrderKind = "ASK", "LIMIT"
+		orders[i].Price, orders[i].Amount = decimal.NewFromInt(10), decimal.NewFromInt(2)
+		if err := rc.AddOrderToBook(orders[i], "BTC_USDT"); err != nil {
+			t.Fatalf("AddOrderToBook(%s) 返回错误: %v", orders[i].OrderID, err)
+		}
+	}
+	members := func(key string) string {
+		ids := rc.client.SMembers(rc.ctx, key).Val()
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+	check := func(want map[string]string) {
+		t.Helper()
+		for key, ids := range want {
+			if got := members(key); got != ids {
+				t.Errorf("%s = [%s], 期望 [%s]", key, got, ids)
+			}
+		}
+	}
+	check(map[string]string{userOrdersKey(1): "a,b,c", apiKeyOrdersKey("key-1"): "a,b", sessionOrdersKey("s-1"): "a"})
+
+	found, err := rc.FindOrdersByUser(1, orderFilter{APIKey: "key-1"})
+	if err != nil || len(found["asks:BTC_USDT"]) != 2 {
+		t.Fatalf("FindOrdersByUser = %v, %v, 期望 2 笔订单", found, err)
+	}
+
+	// 部分成交不改变索引，完全成交和撤单时移出索引
+	fills := []BookFill{{Order: orders[0], Amount: decimal.NewFromInt(2)}, {Order: orders[1], Amount: decimal.NewFromInt(1)}}
+	if _, err := rc.ApplyFills("BTC_USDT", fills, nil, journalEntry{}); err != nil {
+		t.Fatalf("ApplyFills 返回错误: %v", err)
+	}
+	check(map[string]string{userOrdersKey(1): "b,c", apiKeyOrdersKey("key-1"): "b", sessionOrdersKey("s-1"): ""})
+
+	if _, err := rc.RemoveOrder("asks:BTC_USDT", "b", journalEntry{}); err != nil {
+		t.Fatalf("RemoveOrder 返回错误: %v", err)
+	}
+	check(map[string]string{userOrdersKey(1): "c", apiKeyOrdersKey("key-1"): ""})
+}
+
+func TestAddOrderToBookRejectsPrice(t *testing.T) {
+	rc := newTestRedisClient(t)
+	for _, price := range []string{"1000000000000000000000000", "1.1234567890123456789"} {
+		order := Order{
+			OrderID:   uuid.New().String(),
+			UserID:    1,
+			OrderType: "BID",
+			OrderKind: "LIMIT",
+			Price:     decimal.RequireFromString(price),
+			Amount:    decimal.NewFromInt(1),
+		}
+		if err := rc.AddOrderToBook(order, "BTC_USDT"); err == nil {
+			t.Errorf("AddOrderToBook(%s) 应返回错误", price)
+		}
+		_, encodeErr := encodePriceLevel(order.Price)
+		if err := validateOrder(defaultPair, &order); err == nil || encodeErr == nil || !strings.Contains(err.Error(), encodeErr.Error()) {
+			t.Errorf("validateOrder(%s) = %v, 应拒绝超出编码范围的价格", price, err)
+		}
+	}
+	if keys := rc.client.Keys(rc.ctx, "*").Val(); len(keys) != 0 {
+		t.Errorf("无效价格不应写入订单簿, 现有键 %v", keys)
+	}
+}
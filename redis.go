@@ -8,22 +8,27 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/shopspring/decimal"
 )
 
 const (
+	commandResultTTL = time.Minute     // 指令结果的保留时间
+	marketStatesKey  = "market_states" // 哈希，交易对 -> MarketState JSON
 	defaultRedisAddr = "127.0.0.1:6380"
 	defaultRedisDB   = 1
-	pricePrecision   = 1e8   // 1e8，8 位小数
-	maxTimestampDiff = 86400 // 最大时间差（秒）
 )
 
+// BookChangeFunc 订单簿变化回调，side 为 bids 或 asks，delta 为该价位数量的变化量
+type BookChangeFunc func(pair, side string, price, delta decimal.Decimal)
+
 // RedisClient Redis 客户端
 type RedisClient struct {
-	client *redis.Client
-	ctx    context.Context
+	client       *redis.Client
+	ctx          context.Context
+	onBookChange BookChangeFunc
 }
 
 func getRedisAddr() string {
@@ -76,142 +81,309 @@ func (rc *RedisClient) Close() {
 	rc.client.Close()
 }
 
-func (rc *RedisClient) InitOrderBook(pair string) error {
-	return rc.client.Del(rc.ctx, "bids:"+pair, "asks:"+pair).Err()
+// OnBookChange 注册订单簿变化回调，需在撮合开始前调用
+func (rc *RedisClient) OnBookChange(fn BookChangeFunc) {
+	rc.onBookChange = fn
+}
+
+func (rc *RedisClient) notifyBookChange(redisKey string, price, delta decimal.Decimal) {
+	if rc.onBookChange == nil {
+		return
+	}
+	side, pair, _ := strings.Cut(redisKey, ":")
+	rc.onBookChange(pair, side, price, delta)
 }
 
 func (rc *RedisClient) SubmitOrder(order Order) error {
-	orderJSON, err := json.Marshal(order)
+	return rc.SubmitCommand(EngineCommand{Type: CommandNew, Order: &order})
+}
+
+func (rc *RedisClient) SubmitCancel(orderID string, userID int) error {
+	return rc.SubmitCommand(EngineCommand{Type: CommandCancel, OrderID: orderID, UserID: userID})
+}
+
+// SubmitCommand 发布指令到 incoming_orders，由撮合引擎按顺序处理
+func (rc *RedisClient) SubmitCommand(cmd EngineCommand) error {
+	cmdJSON, err := json.Marshal(cmd)
 	if err != nil {
-		log.Printf("序列化订单失败: %v", err)
+		log.Printf("序列化指令失败: %v", err)
 		return err
 	}
-	log.Printf("发布订单到通道 incoming_orders: %s", orderJSON)
-	return rc.client.Publish(rc.ctx, "incoming_orders", orderJSON).Err()
+	log.Printf("发布指令到通道 incoming_orders: %s", cmdJSON)
+	return rc.client.Publish(rc.ctx, "incoming_orders", cmdJSON).Err()
+}
+
+// NextSequence 分配交易对内单调递增的订单到达序号，保存在 Redis 中，重启后继续递增
+func (rc *RedisClient) NextSequence(pair string) (int64, error) {
+	return rc.client.Incr(rc.ctx, "engine_seq:"+pair).Result()
+}
+
+// outboxPublishedKey 已发布到 Redis 的最大 outbox id
+const outboxPublishedKey = "outbox:published"
+
+// publishOutboxScript 只发布 id 大于发布进度的消息，发布和推进进度是原子的
+var publishOutboxScript = redis.NewScript(`
+local last = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) <= last then
+	return 0
+end
+redis.call('PUBLISH', ARGV[2], ARGV[3])
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// PublishOutbox 发布一条 outbox 消息到 channel，id 不大于已发布进度时跳过
+func (rc *RedisClient) PublishOutbox(id uint64, channel, payload string) error {
+	return publishOutboxScript.Run(rc.ctx, rc.client, []string{outboxPublishedKey}, id, channel, payload).Err()
+}
+
+// journalData 持久化日志条目在 Stream 中保存的内容，entry 为空时返回空字符串
+func journalData(entry journalEntry) (string, error) {
+	if entry.empty() {
+		return "", nil
+	}
+	data, err := json.Marshal(entry)
+	return string(data), err
+}
+
+// AppendJournal 追加一条持久化日志，返回条目 ID
+func (rc *RedisClient) AppendJournal(entry journalEntry) (string, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return rc.client.XAdd(rc.ctx, &redis.XAddArgs{Stream: journalStream, Values: map[string]interface{}{"entry": data}}).Result()
 }
 
-func (rc *RedisClient) AddOrderToBook(order Order, pair string) error {
-	redisKey := "bids:" + pair
-	if order.OrderType == "ASK" {
-		redisKey = "asks:" + pair
+// LastJournalID 日志中最后一条记录的 ID，日志为空时返回空字符串。
+// 已删除的记录都已写入数据库，不需要考虑
+func (rc *RedisClient) LastJournalID() (string, error) {
+	messages, err := rc.client.XRevRangeN(rc.ctx, journalStream, "+", "-", 1).Result()
+	if err != nil || len(messages) == 0 {
+		return "", err
 	}
-	// 截断价格到 8 位小数
-	price := order.Price.Round(8)
-	if price.LessThanOrEqual(decimal.Zero) || price.GreaterThan(decimal.NewFromInt(1000000)) {
-		log.Printf("无效价格: %v", price)
-		return fmt.Errorf("价格超出范围: %v", price)
+	return messages[0].ID, nil
+}
+
+// ReadJournal 按顺序读取 ID 在 after 之后的最多 count 条日志，after 为空时从头读取
+func (rc *RedisClient) ReadJournal(after string, count int64) ([]journalRecord, error) {
+	start := "-"
+	if after != "" {
+		start = after
 	}
-	orderJSON, err := json.Marshal(order)
+	// 从 after 开始读取并跳过 after 本身，兼容不支持排他区间的 Redis 版本
+	messages, err := rc.client.XRangeN(rc.ctx, journalStream, start, "+", count+1).Result()
 	if err != nil {
-		log.Printf("序列化订单失败: %v", err)
+		return nil, err
+	}
+	var records []journalRecord
+	for _, message := range messages {
+		if message.ID == after || int64(len(records)) == count {
+			continue
+		}
+		data, _ := message.Values["entry"].(string)
+		var entry journalEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("解析持久化日志 %s 失败: %v", message.ID, err)
+		}
+		records = append(records, journalRecord{ID: message.ID, Entry: entry})
+	}
+	return records, nil
+}
+
+// AckJournal 删除已写入数据库的日志
+func (rc *RedisClient) AckJournal(ids ...string) error {
+	return rc.client.XDel(rc.ctx, journalStream, ids...).Err()
+}
+
+func (rc *RedisClient) SubscribeCommands(channel string, handler func(EngineCommand)) {
+	pubsub := rc.client.Subscribe(rc.ctx, channel)
+	log.Printf("订阅通道: %s", channel)
+	for msg := range pubsub.Channel() {
+		log.Printf("收到消息: %s", msg.Payload)
+		var cmd EngineCommand
+		if err := json.Unmarshal([]byte(msg.Payload), &cmd); err != nil {
+			log.Printf("解析指令失败: %v, 消息: %s", err, msg.Payload)
+			continue
+		}
+		handler(cmd)
+	}
+}
+
+func (rc *RedisClient) SubscribeTrades(channel string, handler func(Trade)) {
+	pubsub := rc.client.Subscribe(rc.ctx, channel)
+	log.Printf("订阅通道: %s", channel)
+	for msg := range pubsub.Channel() {
+		var trade Trade
+		if err := json.Unmarshal([]byte(msg.Payload), &trade); err != nil {
+			log.Printf("解析成交失败: %v, 消息: %s", err, msg.Payload)
+			continue
+		}
+		handler(trade)
+	}
+}
+
+func (rc *RedisClient) SubscribeOrderEvents(channel string, handler func(OrderEvent)) {
+	pubsub := rc.client.Subscribe(rc.ctx, channel)
+	log.Printf("订阅通道: %s", channel)
+	for msg := range pubsub.Channel() {
+		var event OrderEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			log.Printf("解析订单事件失败: %v, 消息: %s", err, msg.Payload)
+			continue
+		}
+		handler(event)
+	}
+}
+
+func (rc *RedisClient) SubscribeBalanceUpdates(channel string, handler func(BalanceUpdate)) {
+	pubsub := rc.client.Subscribe(rc.ctx, channel)
+	log.Printf("订阅通道: %s", channel)
+	for msg := range pubsub.Channel() {
+		var update BalanceUpdate
+		if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil {
+			log.Printf("解析余额变化失败: %v, 消息: %s", err, msg.Payload)
+			continue
+		}
+		handler(update)
+	}
+}
+
+// PublishCommandResult 写入指令结果，由 AwaitCommandResult 读取
+func (rc *RedisClient) PublishCommandResult(result CommandResult) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("序列化指令结果失败: %v", err)
 		return err
 	}
-	priceScore := price.Mul(decimal.NewFromInt(pricePrecision)) // Price * 1e8
-	log.Printf("添加订单到 %s, 原始价格: %v, 截断价格: %v, 时间戳: %v, 分值: %v",
-		redisKey, order.Price, price, order.Timestamp, priceScore)
-	return rc.client.ZAdd(rc.ctx, redisKey, &redis.Z{Score: priceScore.InexactFloat64(), Member: orderJSON}).Err()
+	key := "command_results:" + result.RequestID
+	pipe := rc.client.TxPipeline()
+	pipe.RPush(rc.ctx, key, resultJSON)
+	pipe.Expire(rc.ctx, key, commandResultTTL)
+	_, err = pipe.Exec(rc.ctx)
+	return err
 }
 
-func (rc *RedisClient) GetBestOrder(redisKey string) (*Order, decimal.Decimal, error) {
-	bestOrders, err := rc.client.ZRangeWithScores(rc.ctx, redisKey, 0, 0).Result()
-	if err != nil || len(bestOrders) == 0 {
-		return nil, decimal.Zero, err
+// AwaitCommandResult 等待撮合引擎写入指令结果
+func (rc *RedisClient) AwaitCommandResult(requestID string, timeout time.Duration) (CommandResult, error) {
+	var result CommandResult
+	values, err := rc.client.BLPop(rc.ctx, timeout, "command_results:"+requestID).Result()
+	if err == redis.Nil {
+		return result, fmt.Errorf("等待指令结果超时")
 	}
-	var bestOrder Order
-	if err := json.Unmarshal([]byte(bestOrders[0].Member.(string)), &bestOrder); err != nil {
-		log.Printf("解析最佳订单失败: %v", err)
-		return nil, decimal.Zero, err
+	if err != nil {
+		return result, err
 	}
-	// 提取价格部分
-	score := decimal.NewFromFloat(bestOrders[0].Score)
-	bestPrice := score.Floor().Div(decimal.NewFromInt(pricePrecision))
-	log.Printf("获取最佳订单: %+v, 分值: %v, 价格: %v", bestOrder, score, bestPrice)
-	return &bestOrder, bestPrice, nil
+	if err := json.Unmarshal([]byte(values[1]), &result); err != nil {
+		return result, fmt.Errorf("解析指令结果失败: %v", err)
+	}
+	return result, nil
+}
+
+// ClaimSignature 记录一次性使用的签名，ttl 内同一签名再次使用时返回 false
+func (rc *RedisClient) ClaimSignature(signature string, ttl time.Duration) (bool, error) {
+	return rc.client.SetNX(rc.ctx, "used_signatures:"+strings.ToLower(signature), 1, ttl).Result()
 }
 
-func (rc *RedisClient) RemoveOrder(redisKey string, orderJSON string) error {
-	return rc.client.ZRem(rc.ctx, redisKey, orderJSON).Err()
+// SetCountdown 设置或刷新倒计时撤单的截止时间（毫秒）
+func (rc *RedisClient) SetCountdown(member string, deadline int64) error {
+	return rc.client.ZAdd(rc.ctx, countdownDeadlineKey, &redis.Z{Score: float64(deadline), Member: member}).Err()
 }
 
-func (rc *RedisClient) GetOrdersByPrice(redisKey string, price decimal.Decimal) ([]Order, error) {
-	// 截断价格到 8 位小数
-	priceScore := price.Mul(decimal.NewFromInt(pricePrecision))
-	// 分值范围：priceScore 到 priceScore + maxTimestampDiff/1e8
+// ClearCountdown 关闭倒计时撤单
+func (rc *RedisClient) ClearCountdown(member string) error {
+	return rc.client.ZRem(rc.ctx, countdownDeadlineKey, member).Err()
+}
 
-	results, err := rc.client.ZRangeByScoreWithScores(rc.ctx, redisKey, &redis.ZRangeBy{
-		Min:    priceScore.String(),
-		Max:    priceScore.String(),
-		Offset: 0,
-		Count:  -1,
+// PopExpiredCountdowns 取出并删除已到期的倒计时，只有成功删除的成员会被返回，避免重复触发
+func (rc *RedisClient) PopExpiredCountdowns(now int64) ([]string, error) {
+	members, err := rc.client.ZRangeByScore(rc.ctx, countdownDeadlineKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now, 10),
 	}).Result()
 	if err != nil {
-		log.Printf("获取同价订单失败: %v", err)
 		return nil, err
 	}
-
-	orders := make([]Order, 0, len(results))
-	for _, result := range results {
-		var order Order
-		if err := json.Unmarshal([]byte(result.Member.(string)), &order); err != nil {
-			log.Printf("解析订单失败: %v", err)
-			continue
+	var expired []string
+	for _, member := range members {
+		removed, err := rc.client.ZRem(rc.ctx, countdownDeadlineKey, member).Result()
+		if err != nil {
+			return expired, err
 		}
-		// 验证价格匹配
-		roundedPrice := order.Price.Round(8)
-		if roundedPrice.Equal(price) {
-			orders = append(orders, order)
+		if removed > 0 {
+			expired = append(expired, member)
 		}
 	}
-
-	return orders, nil
+	return expired, nil
 }
 
-func (rc *RedisClient) PublishTrade(trade Trade) error {
-	tradeJSON, err := json.Marshal(trade)
+// SaveMarketState 保存交易对状态，重启后由 LoadMarketStates 恢复
+func (rc *RedisClient) SaveMarketState(state MarketState) error {
+	stateJSON, err := json.Marshal(state)
 	if err != nil {
-		log.Printf("序列化交易失败: %v", err)
 		return err
 	}
-	log.Printf("发布交易到通道 completed_trades: %s", tradeJSON)
-	err = rc.client.Publish(rc.ctx, "completed_trades", tradeJSON).Err()
+	return rc.client.HSet(rc.ctx, marketStatesKey, state.Pair, stateJSON).Err()
+}
+
+// LoadMarketStates 读取全部交易对状态
+func (rc *RedisClient) LoadMarketStates() ([]MarketState, error) {
+	values, err := rc.client.HGetAll(rc.ctx, marketStatesKey).Result()
 	if err != nil {
-		log.Printf("发布交易到 completed_trades 失败: %v", err)
+		return nil, err
+	}
+	states := make([]MarketState, 0, len(values))
+	for pair, value := range values {
+		var state MarketState
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			log.Printf("解析交易对 %s 状态失败: %v", pair, err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (rc *RedisClient) PublishMarketState(state MarketState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("序列化交易对状态失败: %v", err)
 		return err
 	}
-	return nil
+	return rc.client.Publish(rc.ctx, "market_state_updates", stateJSON).Err()
 }
 
-func (rc *RedisClient) SubscribeOrders(channel string, handler func(Order)) {
+func (rc *RedisClient) SubscribeMarketStates(channel string, handler func(MarketState)) {
 	pubsub := rc.client.Subscribe(rc.ctx, channel)
 	log.Printf("订阅通道: %s", channel)
 	for msg := range pubsub.Channel() {
-		log.Printf("收到消息: %s", msg.Payload)
-		var order Order
-		if err := json.Unmarshal([]byte(msg.Payload), &order); err != nil {
-			log.Printf("解析订单失败: %v, 消息: %s", err, msg.Payload)
+		var state MarketState
+		if err := json.Unmarshal([]byte(msg.Payload), &state); err != nil {
+			log.Printf("解析交易对状态失败: %v, 消息: %s", err, msg.Payload)
 			continue
 		}
-		log.Printf("解析订单成功: %+v", order)
-		handler(order)
+		handler(state)
 	}
 }
 
-func (rc *RedisClient) GetAllOrders(redisKey string) ([]Order, error) {
-	results, err := rc.client.ZRangeWithScores(rc.ctx, redisKey, 0, -1).Result()
+func (rc *RedisClient) PublishAuctionIndicative(indicative AuctionIndicative) error {
+	indicativeJSON, err := json.Marshal(indicative)
 	if err != nil {
-		log.Printf("获取所有订单失败: %v", err)
-		return nil, err
+		log.Printf("序列化集合竞价参考价失败: %v", err)
+		return err
 	}
+	return rc.client.Publish(rc.ctx, "auction_updates", indicativeJSON).Err()
+}
 
-	orders := make([]Order, 0, len(results))
-	for _, result := range results {
-		var order Order
-		if err := json.Unmarshal([]byte(result.Member.(string)), &order); err != nil {
-			log.Printf("解析订单失败: %v", err)
+func (rc *RedisClient) SubscribeAuctionIndicative(channel string, handler func(AuctionIndicative)) {
+	pubsub := rc.client.Subscribe(rc.ctx, channel)
+	log.Printf("订阅通道: %s", channel)
+	for msg := range pubsub.Channel() {
+		var indicative AuctionIndicative
+		if err := json.Unmarshal([]byte(msg.Payload), &indicative); err != nil {
+			log.Printf("解析集合竞价参考价失败: %v, 消息: %s", err, msg.Payload)
 			continue
 		}
-		orders = append(orders, order)
+		handler(indicative)
 	}
-
-	return orders, nil
 }
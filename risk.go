@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/shopspring/decimal"
+)
+
+var errRiskCheck = newAPIError(http.StatusInternalServerError, ErrCodeInternal, "风控检查失败")
+
+// riskRequest 一次下单前风控检查的上下文，规则按需读取并共享查询结果
+type riskRequest struct {
+	pc       *PostgresClient
+	rc       *RedisClient
+	order    Order
+	market   MarketConfig
+	limits   *RiskLimitModel
+	exclude  []string // 不计入挂单的订单：待检查的订单本身，以及改单时被替换的原订单
+	exposure *Exposure
+	synced   bool // 是否已等待持久化日志写入数据库
+}
+
+// syncJournal 挂单和成交由撮合引擎经持久化日志异步写入数据库，查询前等待已追加的日志全部写入，
+// 使查询结果包含已撮合的成交和状态变化。每次检查只等待一次
+func (req *riskRequest) syncJournal() error {
+	if !req.synced {
+		if err := waitJournalWritten(req.rc, journalWaitTimeout); err != nil {
+			return err
+		}
+		req.synced = true
+	}
+	return nil
+}
+
+// loadExposure 懒加载用户在该交易对基础币种上的敞口
+func (req *riskRequest) loadExposure() (*Exposure, error) {
+	if req.exposure == nil {
+		if err := req.syncJournal(); err != nil {
+			return nil, err
+		}
+		exposure, err := req.pc.GetExposure(req.order.UserID, pairsWithBase(req.market.BaseAsset), req.exclude)
+		if err != nil {
+			return nil, err
+		}
+		req.exposure = exposure
+	}
+	return req.exposure, nil
+}
+
+// referencePrice 价格参考：最近成交价，尚无成交时取盘口中间价
+func (req *riskRequest) referencePrice() (decimal.Decimal, bool) {
+	if last := defaultMarketData.latestTicker(req.market.Pair).LastPrice; last.IsPositive() {
+		return last, true
+	}
+	return getDepthState(req.market.Pair).midPrice()
+}
+
+// notional 订单的名义价值，市价单按参考价估算，无参考价时返回 false
+func (req *riskRequest) notional() (decimal.Decimal, bool) {
+	if req.order.QuoteAmount != nil {
+		return *req.order.QuoteAmount, true
+	}
+	price := req.order.Price
+	if req.order.OrderKind == "MARKET" {
+		var ok bool
+		if price, ok = req.referencePrice(); !ok {
+			return decimal.Zero, false
+		}
+	}
+	return price.Mul(req.order.Amount), true
+}
+
+// amount 订单的基础币种数量，按计价币种下单时按参考价估算，无参考价时返回 false
+func (req *riskRequest) amount() (decimal.Decimal, bool) {
+	if req.order.QuoteAmount == nil {
+		return req.order.Amount, true
+	}
+	ref, ok := req.referencePrice()
+	if !ok {
+		return decimal.Zero, false
+	}
+	return req.order.QuoteAmount.Div(ref), true
+}
+
+// RiskCheck 下单前风控规则。拒绝时返回 *apiError，查询失败等其它错误按内部错误处理
+type RiskCheck interface {
+	Name() string
+	Check(req *riskRequest) error
+}
+
+// riskChecks 风控规则链，按顺序执行，任一规则拒绝即停止
+var riskChecks = []RiskCheck{
+	maxNotionalCheck{},
+	maxOpenOrdersCheck{},
+	maxPositionCheck{},
+	priceCollarCheck{},
+}
+
+// riskRejected 订单未通过风控
+func riskRejected(format string, args ...interface{}) *apiError {
+	return newAPIError(http.StatusBadRequest, ErrCodeRiskRejected, fmt.Sprintf(format, args...))
+}
+
+// checkRisk 在订单提交到撮合引擎前执行风控规则链，限额取自 risk_limits 表中 pair 的配置。
+// 订单须已落库，exclude 为改单时被替换的原订单，不计入挂单和敞口
+func checkRisk(pc *PostgresClient, rc *RedisClient, pair string, order Order, exclude ...string) error {
+	market, ok := getMarket(pair)
+	if !ok {
+		return errUnsupportedPair
+	}
+	limits, err := pc.GetRiskLimit(order.UserID, market.Pair)
+	if err != nil {
+		log.Printf("查询风控限额失败: %v", err)
+		return errRiskCheck
+	}
+	if limits == nil {
+		return nil
+	}
+
+	req := &riskRequest{pc: pc, rc: rc, order: order, market: market, limits: limits, exclude: append([]string{order.OrderID}, exclude...)}
+	for _, check := range riskChecks {
+		if err := check.Check(req); err != nil {
+			if errorCode(err) == ErrCodeInternal {
+				log.Printf("风控规则 %s 执行失败: %v", check.Name(), err)
+				return errRiskCheck
+			}
+			log.Printf("订单 %s 未通过风控规则 %s: %v", order.OrderID, check.Name(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// maxNotionalCheck 单笔订单最大名义价值
+type maxNotionalCheck struct{}
+
+func (maxNotionalCheck) Name() string { return "max_order_notional" }
+
+func (maxNotionalCheck) Check(req *riskRequest) error {
+	if !req.limits.MaxOrderNotional.IsPositive() {
+		return nil
+	}
+	notional, ok := req.notional()
+	if !ok {
+		return nil
+	}
+	if limit := req.limits.MaxOrderNotional; notional.GreaterThan(limit) {
+		return riskRejected("订单名义价值 %s 超过上限 %s %s", notional, limit, req.market.QuoteAsset)
+	}
+	return nil
+}
+
+// maxOpenOrdersCheck 交易对上的最大挂单数，包含本订单
+type maxOpenOrdersCheck struct{}
+
+func (maxOpenOrdersCheck) Name() string { return "max_open_orders" }
+
+func (maxOpenOrdersCheck) Check(req *riskRequest) error {
+	// 市价单不会挂单
+	if req.limits.MaxOpenOrders <= 0 || req.order.OrderKind == "MARKET" {
+		return nil
+	}
+	if err := req.syncJournal(); err != nil {
+		return err
+	}
+	count, err := req.pc.CountOpenOrders(req.order.UserID, req.market.Pair, req.exclude)
+	if err != nil {
+		return err
+	}
+	if count >= int64(req.limits.MaxOpenOrders) {
+		return riskRejected("挂单数已达上限 %d", req.limits.MaxOpenOrders)
+	}
+	return nil
+}
+
+// maxPositionCheck 基础币种最大敞口。假设同方向挂单和本订单全部成交，
+// 买单检查多头敞口，卖单检查空头敞口
+type maxPositionCheck struct{}
+
+func (maxPositionCheck) Name() string { return "max_position" }
+
+func (maxPositionCheck) Check(req *riskRequest) error {
+	if !req.limits.MaxPosition.IsPositive() {
+		return nil
+	}
+	amount, ok := req.amount()
+	if !ok {
+		return nil
+	}
+	exposure, err := req.loadExposure()
+	if err != nil {
+		return err
+	}
+	position := decimal.NewFromFloat(exposure.Position)
+	var worst decimal.Decimal
+	if req.order.OrderType == "BID" {
+		worst = position.Add(decimal.NewFromFloat(exposure.OpenBids)).Add(amount)
+	} else {
+		worst = position.Sub(decimal.NewFromFloat(exposure.OpenAsks)).Sub(amount).Neg()
+	}
+	if limit := req.limits.MaxPosition; worst.GreaterThan(limit) {
+		return riskRejected("%s 敞口 %s 超过上限 %s", req.market.BaseAsset, worst, limit)
+	}
+	return nil
+}
+
+// priceCollarCheck 限价偏离参考价的比例，尚无参考价时不检查
+type priceCollarCheck struct{}
+
+func (priceCollarCheck) Name() string { return "price_collar" }
+
+func (priceCollarCheck) Check(req *riskRequest) error {
+	if !req.limits.PriceCollarPct.IsPositive() || req.order.OrderKind != "LIMIT" {
+		return nil
+	}
+	ref, ok := req.referencePrice()
+	if !ok {
+		return nil
+	}
+	band := ref.Mul(req.limits.PriceCollarPct).Div(decimal.NewFromInt(100))
+	low, high := ref.Sub(band), ref.Add(band)
+	if req.order.Price.LessThan(low) || req.order.Price.GreaterThan(high) {
+		return riskRejected("价格 %s 偏离参考价 %s 超过 %s%%，允许范围 %s 到 %s",
+			req.order.Price, ref, req.limits.PriceCollarPct, low, high)
+	}
+	return nil
+}
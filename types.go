@@ -28,22 +28,37 @@ import (
 
 // Order 订单结构体
 type Order struct {
-	OrderID   string          `json:"order_id"`
-	UserID    int             `json:"user_id"`
-	OrderType string          `json:"order_type"` // BID 或 ASK
-	OrderKind string          `json:"order_kind"` // LIMIT 或 MARKET
-	Price     decimal.Decimal `json:"price"`
-	Amount    decimal.Decimal `json:"amount"`
-	Timestamp int64           `json:"timestamp"` // Unix 时间戳（秒）
+	OrderID       string          `json:"order_id"`
+	ClientOrderID string          `json:"client_order_id,omitempty"` // 客户端订单 ID，同一用户内唯一
+	UserID        int             `json:"user_id"`
+	APIKey        string          `json:"api_key,omitempty"`    // 下单使用的 API Key，由服务端填写
+	SessionID     string          `json:"session_id,omitempty"` // 开启断线撤单的私有 WebSocket 会话，连接断开时撤销带该会话 ID 的挂单
+	OrderType     string          `json:"order_type"`           // BID 或 ASK
+	OrderKind     string          `json:"order_kind"`           // LIMIT 或 MARKET
+	Price         decimal.Decimal `json:"price"`
+	Amount        decimal.Decimal `json:"amount"`
+	Timestamp     int64           `json:"timestamp"` // Unix 时间戳（秒），由客户端或下单接口填写，不参与排序
+
+	// 撮合引擎收到订单时分配，同价位按 Sequence 决定时间优先，客户端填写的值会被覆盖。
+	// 引入前挂出的订单为 0，排在同价位的最前面
+	Sequence   int64 `json:"sequence,omitempty"`    // 交易对内单调递增的到达序号
+	ReceivedAt int64 `json:"received_at,omitempty"` // 撮合引擎收到订单的时间（纳秒）
+
+	// 以下字段只用于市价单，为空时不序列化
+	QuoteAmount     *decimal.Decimal `json:"quote_amount,omitempty"`     // 按计价币种下单的市价买单预算，此时 amount 为空
+	ProtectionPrice *decimal.Decimal `json:"protection_price,omitempty"` // 保护价，买单不高于、卖单不低于该价格成交
+	MaxSlippage     *decimal.Decimal `json:"max_slippage,omitempty"`     // 相对撮合开始时对手盘最优价的最大偏离比例，如 0.01 表示 1%
 }
 
 // Trade 交易结构体
 type Trade struct {
 	TradeID    string          `json:"trade_id"`
+	Pair       string          `json:"pair"`
 	BidOrderID string          `json:"bid_order_id"`
 	AskOrderID string          `json:"ask_order_id"`
 	Price      decimal.Decimal `json:"price"`
 	Amount     decimal.Decimal `json:"amount"`
+	Timestamp  int64           `json:"timestamp"` // 成交时间（秒）
 }
 
 type OrderBookLevel struct {
@@ -53,7 +68,65 @@ type OrderBookLevel struct {
 
 // 盘口结构体
 type OrderBookSnapshot struct {
-	Pair string           `json:"pair"`
-	Bids []OrderBookLevel `json:"bids"`
-	Asks []OrderBookLevel `json:"asks"`
+	Pair     string           `json:"pair"`
+	UpdateID int64            `json:"update_id"` // 快照对应的增量序号
+	Bids     []OrderBookLevel `json:"bids"`
+	Asks     []OrderBookLevel `json:"asks"`
+}
+
+// 盘口增量，只包含有变化的价位，数量为 0 表示该价位已移除
+type OrderBookUpdate struct {
+	Pair     string           `json:"pair"`
+	UpdateID int64            `json:"update_id"` // 每次增量加 1，不连续说明丢失了消息
+	Bids     []OrderBookLevel `json:"bids"`
+	Asks     []OrderBookLevel `json:"asks"`
+}
+
+// Fill 单笔成交明细
+type Fill struct {
+	TradeID   string          `json:"trade_id"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Liquidity string          `json:"liquidity"` // MAKER、TAKER，集合竞价成交为 AUCTION
+}
+
+// OrderEvent 订单状态变化事件，推送到用户私有频道
+type OrderEvent struct {
+	Event           string          `json:"event"`            // ACCEPTED、PARTIALLY_FILLED、FILLED、CANCELED、EXPIRED 或 REJECTED
+	Status          string          `json:"status,omitempty"` // orders 表中的状态
+	OrderID         string          `json:"order_id"`
+	UserID          int             `json:"user_id"`
+	APIKey          string          `json:"api_key,omitempty"` // 下单使用的 API Key
+	Pair            string          `json:"pair"`
+	OrderType       string          `json:"order_type"`
+	OrderKind       string          `json:"order_kind"`
+	Price           decimal.Decimal `json:"price"`
+	RemainingAmount decimal.Decimal `json:"remaining_amount"` // 未成交数量，按计价币种下单的市价买单为剩余预算
+	Fill            *Fill           `json:"fill,omitempty"`
+	Reason          string          `json:"reason,omitempty"` // REJECTED 或 EXPIRED 的原因
+	Timestamp       int64           `json:"timestamp"`
+}
+
+// BalanceUpdate 成交引起的余额变化
+type BalanceUpdate struct {
+	UserID    int             `json:"user_id"`
+	Asset     string          `json:"asset"`
+	Delta     decimal.Decimal `json:"delta"` // 正数为增加，负数为减少
+	TradeID   string          `json:"trade_id"`
+	Timestamp int64           `json:"timestamp"`
+}
+
+// OrderInfo 订单查询结果
+type OrderInfo struct {
+	OrderID       string           `json:"order_id"`
+	ClientOrderID string           `json:"client_order_id,omitempty"`
+	UserID        int              `json:"user_id"`
+	Pair          string           `json:"pair"`
+	OrderType     string           `json:"order_type"`
+	OrderKind     string           `json:"order_kind"`
+	Price         decimal.Decimal  `json:"price"`
+	Amount        decimal.Decimal  `json:"amount"`
+	QuoteAmount   *decimal.Decimal `json:"quote_amount,omitempty"` // 按计价币种下单的市价买单预算
+	Status        string           `json:"status"`
+	Timestamp     int64            `json:"timestamp"`
 }
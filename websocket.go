@@ -1,34 +1,318 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/shopspring/decimal"
 )
 
+const (
+	wsWriteWait      = 10 * time.Second    // 单次写操作超时
+	wsPongWait       = 60 * time.Second    // 超过该时间未收到 pong 视为断开
+	wsPingPeriod     = wsPongWait * 9 / 10 // ping 间隔，需小于 wsPongWait
+	wsMaxMessageSize = 4096                // 客户端请求的最大长度
+	wsSendQueueSize  = 256                 // 每个连接的发送队列长度
+)
+
 // WebSocket 客户端管理
 var (
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
-	wsClients   = make(map[*websocket.Conn]bool)
-	wsClientsMu sync.Mutex
+	wsClients   = make(map[*wsClient]bool)
+	wsClientsMu sync.Mutex // 同时保护 wsClients 和每个客户端的 subs
 )
 
-// 推送盘口信息到所有 WebSocket 客户端
-func broadcastOrderBook(snapshot OrderBookSnapshot) {
+// wsClient 单个 WebSocket 连接。所有写操作由 writePump 完成，
+// 其他 goroutine 只向 send 队列投递消息，不会被慢连接阻塞
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte     // 待发送消息
+	done      chan struct{}   // 连接关闭后关闭
+	closeOnce sync.Once       // 保证只关闭一次
+	reason    string          // 服务端主动关闭的原因
+	subs      map[string]bool // 已订阅的频道
+	userID    int             // 私有连接认证后的用户，公共连接为 0
+}
+
+// wsRequest 客户端请求
+// {"op":"subscribe","channels":["depth:BTC_USDT","candles:BTC_USDT:1m"],"id":1}
+type wsRequest struct {
+	ID       int64    `json:"id,omitempty"`
+	Op       string   `json:"op"` // subscribe、unsubscribe 或 snapshot（重新获取盘口快照）
+	Channels []string `json:"channels"`
+}
+
+// wsResponse 请求应答或错误
+type wsResponse struct {
+	ID       int64    `json:"id,omitempty"`
+	Event    string   `json:"event"` // subscribed、unsubscribed、snapshot、session 或 error
+	Channels []string `json:"channels,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	// SessionID 开启断线撤单的私有连接建立后推送一次 session 事件，下单时带上该 ID 的订单在连接断开后撤销
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// wsMessage 频道推送消息
+type wsMessage struct {
+	Channel string      `json:"channel"`
+	Type    string      `json:"type,omitempty"` // depth 频道: snapshot 或 update
+	Data    interface{} `json:"data"`
+}
+
+func newWSClient(conn *websocket.Conn, userID int) *wsClient {
+	return &wsClient{
+		conn:   conn,
+		send:   make(chan []byte, wsSendQueueSize),
+		done:   make(chan struct{}),
+		subs:   make(map[string]bool),
+		userID: userID,
+	}
+}
+
+// sendJSON 序列化后投递到发送队列
+func (c *wsClient) sendJSON(v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("序列化 WebSocket 消息失败: %v", err)
+		return false
+	}
+	return c.enqueue(data)
+}
+
+// enqueue 非阻塞投递消息。队列已满说明客户端消费过慢，直接断开连接，
+// 客户端重连并重新订阅后会收到新的盘口快照
+func (c *wsClient) enqueue(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		log.Printf("WebSocket 客户端 %s 发送队列已满，断开连接", c.conn.RemoteAddr())
+		c.close("slow consumer")
+		return false
+	}
+}
+
+// close 注销连接并通知 writePump 退出，可重复调用
+func (c *wsClient) close(reason string) {
+	c.closeOnce.Do(func() {
+		wsClientsMu.Lock()
+		delete(wsClients, c)
+		wsClientsMu.Unlock()
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+// writePump 串行发送队列中的消息，并定期发送 ping
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.close("")
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close("")
+				return
+			}
+		case <-c.done:
+			if c.reason != "" {
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, c.reason)
+				c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait))
+			}
+			return
+		}
+	}
+}
+
+// parseChannel 校验频道名，格式为 depth:<pair>、trades:<pair>、ticker:<pair>、state:<pair>、auction:<pair> 或 candles:<pair>:<interval>
+func parseChannel(channel string) error {
+	parts := strings.Split(channel, ":")
+	if len(parts) < 2 {
+		return fmt.Errorf("无效的频道: %s", channel)
+	}
+	if _, ok := getMarket(parts[1]); !ok {
+		return fmt.Errorf("不支持的交易对: %s", parts[1])
+	}
+	switch parts[0] {
+	case "depth", "trades", "ticker", "state", "auction":
+		if len(parts) != 2 {
+			return fmt.Errorf("无效的频道: %s", channel)
+		}
+	case "candles":
+		if len(parts) != 3 {
+			return fmt.Errorf("K 线频道缺少周期: %s", channel)
+		}
+		if _, ok := candleIntervals[parts[2]]; !ok {
+			return fmt.Errorf("不支持的 K 线周期: %s", parts[2])
+		}
+	default:
+		return fmt.Errorf("未知的频道类型: %s", parts[0])
+	}
+	return nil
+}
+
+// handleRequest 处理订阅/取消订阅请求
+func (c *wsClient) handleRequest(req wsRequest) {
+	if req.Op != "subscribe" && req.Op != "unsubscribe" && req.Op != "snapshot" {
+		c.sendJSON(wsResponse{ID: req.ID, Event: "error", Message: "未知操作: " + req.Op})
+		return
+	}
+	if len(req.Channels) == 0 {
+		c.sendJSON(wsResponse{ID: req.ID, Event: "error", Message: "频道列表为空"})
+		return
+	}
+	for _, channel := range req.Channels {
+		if err := parseChannel(channel); err != nil {
+			c.sendJSON(wsResponse{ID: req.ID, Event: "error", Message: err.Error()})
+			return
+		}
+		if req.Op == "snapshot" && !strings.HasPrefix(channel, "depth:") {
+			c.sendJSON(wsResponse{ID: req.ID, Event: "error", Message: "只有 depth 频道支持快照: " + channel})
+			return
+		}
+	}
+
+	if req.Op == "snapshot" {
+		c.sendJSON(wsResponse{ID: req.ID, Event: "snapshot", Channels: req.Channels})
+		for _, channel := range req.Channels {
+			c.sendDepthSnapshot(strings.TrimPrefix(channel, "depth:"), false)
+		}
+		return
+	}
+
+	c.sendJSON(wsResponse{ID: req.ID, Event: req.Op + "d", Channels: req.Channels})
+	for _, channel := range req.Channels {
+		if req.Op == "unsubscribe" {
+			wsClientsMu.Lock()
+			delete(c.subs, channel)
+			wsClientsMu.Unlock()
+		} else if strings.HasPrefix(channel, "depth:") {
+			// 先推送快照，之后的增量从快照的 update_id 开始
+			c.sendDepthSnapshot(strings.TrimPrefix(channel, "depth:"), true)
+		} else if strings.HasPrefix(channel, "state:") {
+			// 先推送当前状态，之后推送变化
+			wsClientsMu.Lock()
+			c.subs[channel] = true
+			wsClientsMu.Unlock()
+			c.sendJSON(wsMessage{Channel: channel, Data: currentMarketState(strings.TrimPrefix(channel, "state:"))})
+		} else {
+			wsClientsMu.Lock()
+			c.subs[channel] = true
+			wsClientsMu.Unlock()
+		}
+	}
+}
+
+// sendDepthSnapshot 推送盘口快照，subscribe 为 true 时同时订阅增量。
+// 持有盘口状态锁期间完成，保证快照与后续增量之间不会遗漏或重复
+func (c *wsClient) sendDepthSnapshot(pair string, subscribe bool) {
+	state := getDepthState(pair)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if subscribe {
+		wsClientsMu.Lock()
+		c.subs["depth:"+pair] = true
+		wsClientsMu.Unlock()
+	}
+	c.sendJSON(wsMessage{Channel: "depth:" + pair, Type: "snapshot", Data: state.snapshot(pair)})
+}
+
+// publish 推送消息到订阅了该频道的客户端
+func publish(channel string, data interface{}) {
+	publishMessage(wsMessage{Channel: channel, Data: data})
+}
+
+func publishMessage(msg wsMessage) {
 	wsClientsMu.Lock()
-	defer wsClientsMu.Unlock()
-	for conn := range wsClients {
-		err := conn.WriteJSON(snapshot)
-		if err != nil {
-			conn.Close()
-			delete(wsClients, conn)
+	var targets []*wsClient
+	for c := range wsClients {
+		if c.subs[msg.Channel] {
+			targets = append(targets, c)
+		}
+	}
+	wsClientsMu.Unlock()
+
+	broadcast(targets, msg)
+}
+
+// broadcast 序列化一次后投递给所有目标连接
+func broadcast(targets []*wsClient, msg wsMessage) {
+	if len(targets) == 0 {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("序列化 WebSocket 消息失败: %v", err)
+		return
+	}
+	for _, c := range targets {
+		c.enqueue(data)
+	}
+}
+
+// 推送盘口增量，调用方需持有该交易对盘口状态的锁，见 runDepthPublisher
+func broadcastOrderBook(update OrderBookUpdate) {
+	publishMessage(wsMessage{Channel: "depth:" + update.Pair, Type: "update", Data: update})
+}
+
+// publishToUser 推送私有消息到该用户的所有私有连接
+func publishToUser(userID int, channel string, data interface{}) {
+	wsClientsMu.Lock()
+	var targets []*wsClient
+	for c := range wsClients {
+		if c.userID != 0 && c.userID == userID {
+			targets = append(targets, c)
 		}
 	}
+	wsClientsMu.Unlock()
+
+	broadcast(targets, wsMessage{Channel: channel, Data: data})
+}
+
+// broadcastOrderEvent 推送订单事件到 orders 私有频道
+func broadcastOrderEvent(event OrderEvent) {
+	publishToUser(event.UserID, "orders", event)
+}
+
+// broadcastBalanceUpdate 推送余额变化到 balances 私有频道
+func broadcastBalanceUpdate(update BalanceUpdate) {
+	publishToUser(update.UserID, "balances", update)
+}
+
+// broadcastTrade 推送成交，并更新行情和 K 线频道
+func broadcastTrade(trade Trade) {
+	publish("trades:"+trade.Pair, trade)
+
+	ticker, candles := defaultMarketData.onTrade(trade)
+	publish("ticker:"+trade.Pair, ticker)
+	for _, candle := range candles {
+		publish("candles:"+candle.Pair+":"+candle.Interval, candle)
+	}
 }
 
 // WebSocket handler
@@ -38,18 +322,79 @@ func wsOrderBookHandler(rc *RedisClient) http.HandlerFunc {
 		if err != nil {
 			return
 		}
-		wsClientsMu.Lock()
-		wsClients[conn] = true
-		wsClientsMu.Unlock()
-		// 可选：初次连接时推送一次盘口
-		// go func() {
-		// 	snapshot := getOrderBookSnapshot(rc, "BTCUSDT") // 示例
-		// 	conn.WriteJSON(snapshot)
-		// }()
+		// 订阅 depth 频道时推送一次快照，见 sendDepthSnapshot
+		serveClient(newWSClient(conn, 0))
+	}
+}
+
+// wsPrivateHandler 用户私有推送，握手请求需经过 authenticate 中间件签名认证。
+// 认证后自动接收本用户的 orders 和 balances 频道，同时支持公共频道订阅。
+// 握手时携带 ?cancel_on_disconnect=true 则为连接分配会话 ID 并通过 session 事件推送，
+// 连接断开后撤销下单时带有该会话 ID 的挂单，同一用户其他连接或请求下的订单不受影响
+func wsPrivateHandler(rc *RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFrom(r)
+		if principal == nil {
+			writeError(w, http.StatusUnauthorized, ErrCodeMissingAuth, "未认证")
+			return
+		}
+		cancelOnDisconnect := r.URL.Query().Get("cancel_on_disconnect") == "true"
+		if cancelOnDisconnect && !principal.HasScope(ScopeTrade) {
+			writeError(w, http.StatusForbidden, ErrCodePermissionDenied, "API Key 没有 trade 权限")
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := newWSClient(conn, principal.UserID)
+		var sessionID string
+		if cancelOnDisconnect {
+			sessionID = uuid.New().String()
+			client.sendJSON(wsResponse{Event: "session", SessionID: sessionID})
+		}
+		serveClient(client)
+
+		if cancelOnDisconnect {
+			log.Printf("私有连接断开，撤销用户 %d 会话 %s 的挂单", principal.UserID, sessionID)
+			cmd := EngineCommand{Type: CommandCancelAll, UserID: principal.UserID, SessionID: sessionID}
+			if err := rc.SubmitCommand(cmd); err != nil {
+				log.Printf("提交断线撤单失败: %v", err)
+			}
+		}
+	}
+}
+
+// serveClient 注册连接并读取客户端请求，直到连接断开或心跳超时
+func serveClient(client *wsClient) {
+	wsClientsMu.Lock()
+	wsClients[client] = true
+	wsClientsMu.Unlock()
+	go client.writePump()
+
+	defer client.close("")
+	client.conn.SetReadLimit(wsMaxMessageSize)
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+	for {
+		_, data, err := client.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Printf("解析 WebSocket 请求失败: %v", err)
+			client.sendJSON(wsResponse{Event: "error", Message: "无效的请求格式"})
+			continue
+		}
+		client.handleRequest(req)
 	}
 }
 
-// 从 Redis 获取订单簿并聚合
+// 从 Redis 获取订单簿并聚合，只用于启动时初始化盘口
 func getOrderBookSnapshot(rc *RedisClient, pair string) OrderBookSnapshot {
 	bidOrders, _ := rc.GetAllOrders("bids:" + pair)
 	askOrders, _ := rc.GetAllOrders("asks:" + pair)